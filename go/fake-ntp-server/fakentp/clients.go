@@ -0,0 +1,88 @@
+package fakentp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ClientProfile geeft clients binnen een of meer prefixen (of exacte
+// adressen) hun eigen Config-overrides, bijvoorbeeld:
+//
+//	{ "match": ["192.0.2.10", "2001:db8::/32"], "config": { "leap_indicator": 3 } }
+//	{ "match": ["198.51.100.0/24"], "config": { "drop": true } }
+//
+// Het eerste profiel dat matcht wint. De overrides worden toegepast op de
+// config die op dat moment geldt (dus ook bovenop een scenario-fase).
+type ClientProfile struct {
+	Match  []string        `json:"match"`
+	Config json.RawMessage `json:"config"`
+
+	prefixes []netip.Prefix
+}
+
+func (p *ClientProfile) UnmarshalJSON(data []byte) error {
+	type plain ClientProfile
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+
+	p.prefixes = nil
+	for _, m := range p.Match {
+		prefix, err := parseMatch(m)
+		if err != nil {
+			return err
+		}
+		p.prefixes = append(p.prefixes, prefix)
+	}
+	return nil
+}
+
+// parseMatch accepteert zowel een prefix ("10.0.0.0/8") als een los adres
+// ("192.0.2.1", "::1"); dat laatste wordt een /32 of /128.
+func parseMatch(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("ongeldig prefix %q: %v", s, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("ongeldig adres %q: %v", s, err)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// addrOf geeft het IP-adres van een client (UDP of TCP) als netip.Addr.
+// IPv4-mapped IPv6 (::ffff:192.0.2.1) wordt gewoon IPv4, zodat het ook zo
+// matcht.
+func addrOf(a net.Addr) netip.Addr {
+	var ip net.IP
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+// matchClient geeft de index van het eerste profiel dat matcht, of -1.
+func matchClient(profiles []ClientProfile, addr netip.Addr) int {
+	if !addr.IsValid() {
+		return -1
+	}
+	for i := range profiles {
+		for _, prefix := range profiles[i].prefixes {
+			if prefix.Contains(addr) {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,307 @@
+package fakentp
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Klokmodellen: hoe de klok van de server afwijkt van de systeemklok. In de
+// config staat een lijst ("clock"); de modellen worden na elkaar toegepast,
+// elk op de uitkomst van het vorige. Bijvoorbeeld drift plus een sprong:
+//
+//	"clock": [
+//	  {"model": "drift", "ppm": 20},
+//	  {"model": "steps", "steps": [{"at_sec": 300, "offset_ms": 500}]}
+//	]
+//
+// De klok is van de hele server; time_offset_ms en jitter_ms komen er per
+// verzoek nog bovenop.
+//
+// Met "epoch" leeft de server in een andere tijd: vanaf een vast moment
+// ({"model": "epoch", "start": "2036-02-07T06:27:00Z"}) of een aantal jaren
+// verschoven ({"model": "epoch", "years": 20}). Zo is de overgang van NTP-era
+// 0 naar 1 (7 februari 2036, 06:28:16 UTC) te testen. Epoch-modellen komen
+// altijd na de andere, die rekenen vanaf de echte starttijd.
+type ClockModel interface {
+	// Adjust geeft de tijd van de server bij (de al aangepaste) tijd t.
+	Adjust(t time.Time) time.Time
+}
+
+type ClockSpec struct {
+	Model string `json:"model"` // offset, drift, random_walk, sine, steps, frozen, epoch
+
+	OffsetMs float64 `json:"offset_ms"` // offset
+
+	PPM               float64 `json:"ppm"`                 // drift, random_walk (begindrift)
+	StepPPM           float64 `json:"step_ppm"`            // random_walk: maximale verandering per stap
+	UpdateIntervalSec float64 `json:"update_interval_sec"` // random_walk: standaard 10
+
+	AmplitudeMs float64 `json:"amplitude_ms"` // sine
+	PeriodSec   float64 `json:"period_sec"`   // sine
+	PhaseDeg    float64 `json:"phase_deg"`    // sine
+
+	Steps []ClockStep `json:"steps"` // steps
+
+	AfterSec float64 `json:"after_sec"` // frozen: na zoveel seconden blijft de klok staan
+
+	Start string `json:"start"` // epoch: tijd bij het starten (RFC 3339)
+	Years int    `json:"years"` // epoch: of zoveel jaar verschoven
+}
+
+// ClockStep is een sprong van offset_ms, at_sec seconden na het starten.
+// Sprongen tellen op.
+type ClockStep struct {
+	AtSec    float64 `json:"at_sec"`
+	OffsetMs float64 `json:"offset_ms"`
+}
+
+func validateClock(specs []ClockSpec) error {
+	for i, c := range specs {
+		var err error
+		switch c.Model {
+		case "offset", "drift", "steps":
+		case "random_walk":
+			if c.StepPPM < 0 || c.UpdateIntervalSec < 0 {
+				err = fmt.Errorf("step_ppm en update_interval_sec moeten >= 0 zijn")
+			}
+		case "sine":
+			if c.PeriodSec <= 0 {
+				err = fmt.Errorf("period_sec moet > 0 zijn")
+			}
+		case "frozen":
+			if c.AfterSec < 0 {
+				err = fmt.Errorf("after_sec moet >= 0 zijn")
+			}
+		case "epoch":
+			err = validateEpoch(c)
+		default:
+			err = fmt.Errorf("onbekend model %q (offset, drift, random_walk, sine, steps, frozen, epoch)", c.Model)
+		}
+		if err != nil {
+			return fmt.Errorf("Klokmodel %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// newClock maakt de modellen uit de config; start is het nulpunt voor
+// drift, sprongen enzovoort. Elk random_walk-model krijgt een eigen bron
+// uit seeds.
+func newClock(specs []ClockSpec, start time.Time, seeds *rand.Rand) []ClockModel {
+	var models, epochs []ClockModel
+	for _, c := range specs {
+		switch c.Model {
+		case "offset":
+			models = append(models, offsetClock{msDuration(c.OffsetMs)})
+		case "drift":
+			models = append(models, driftClock{start: start, ppm: c.PPM})
+		case "random_walk":
+			every := 10 * time.Second
+			if c.UpdateIntervalSec > 0 {
+				every = time.Duration(c.UpdateIntervalSec * float64(time.Second))
+			}
+			models = append(models, &randomWalkClock{
+				last:    start,
+				ppm:     c.PPM,
+				stepPPM: c.StepPPM,
+				every:   every,
+				rand:    rand.New(rand.NewSource(seeds.Int63())),
+			})
+		case "sine":
+			models = append(models, sineClock{
+				start:     start,
+				amplitude: msDuration(c.AmplitudeMs),
+				period:    time.Duration(c.PeriodSec * float64(time.Second)),
+				phase:     c.PhaseDeg * math.Pi / 180,
+			})
+		case "steps":
+			s := stepsClock{}
+			for _, st := range c.Steps {
+				s.steps = append(s.steps, clockStep{
+					at:     start.Add(time.Duration(st.AtSec * float64(time.Second))),
+					offset: msDuration(st.OffsetMs),
+				})
+			}
+			sort.Slice(s.steps, func(i, j int) bool { return s.steps[i].at.Before(s.steps[j].at) })
+			models = append(models, s)
+		case "frozen":
+			models = append(models, frozenClock{at: start.Add(time.Duration(c.AfterSec * float64(time.Second)))})
+		case "epoch":
+			epochs = append(epochs, newEpochClock(c, start))
+		}
+	}
+	return append(models, epochs...)
+}
+
+// driftSpecs zet de oude drift-opties van fake-ntp-server-2
+// (drift_model, drift_ppm, ...) om naar klokmodellen.
+func driftSpecs(cfg Config) []ClockSpec {
+	switch cfg.DriftModel {
+	case "random_walk":
+		return []ClockSpec{{
+			Model:             "random_walk",
+			PPM:               cfg.DriftPPM,
+			StepPPM:           cfg.DriftStepPPM,
+			UpdateIntervalSec: float64(cfg.DriftUpdateSec),
+		}}
+	}
+	return nil
+}
+
+func clockString(specs []ClockSpec) string {
+	var names, epochs []string
+	for _, c := range specs {
+		if c.Model == "epoch" {
+			epochs = append(epochs, c.Model)
+			continue
+		}
+		names = append(names, c.Model)
+	}
+	return strings.Join(append(names, epochs...), " -> ")
+}
+
+func msDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func ppmDuration(d time.Duration, ppm float64) time.Duration {
+	return time.Duration(float64(d) * ppm / 1e6)
+}
+
+// offsetClock: een vaste afwijking.
+type offsetClock struct {
+	offset time.Duration
+}
+
+func (c offsetClock) Adjust(t time.Time) time.Time {
+	return t.Add(c.offset)
+}
+
+// driftClock: een vaste frequentiefout; de afwijking groeit lineair.
+type driftClock struct {
+	start time.Time
+	ppm   float64
+}
+
+func (c driftClock) Adjust(t time.Time) time.Time {
+	return t.Add(ppmDuration(t.Sub(c.start), c.ppm))
+}
+
+// randomWalkClock: de frequentiefout verandert elke interval met een
+// willekeurige stap tussen -stepPPM en +stepPPM. De afwijking tot dan
+// blijft bewaard, dus de klok maakt geen sprongen.
+type randomWalkClock struct {
+	mu      sync.Mutex
+	last    time.Time     // begin van het huidige interval
+	offset  time.Duration // afwijking op last
+	ppm     float64
+	stepPPM float64
+	every   time.Duration
+	rand    *rand.Rand
+}
+
+func (c *randomWalkClock) Adjust(t time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for t.Sub(c.last) >= c.every {
+		c.offset += ppmDuration(c.every, c.ppm)
+		c.last = c.last.Add(c.every)
+		c.ppm += (c.rand.Float64()*2 - 1) * c.stepPPM
+	}
+	return t.Add(c.offset + ppmDuration(t.Sub(c.last), c.ppm))
+}
+
+// sineClock: een periodieke afwijking, bijvoorbeeld door temperatuur.
+type sineClock struct {
+	start     time.Time
+	amplitude time.Duration
+	period    time.Duration
+	phase     float64
+}
+
+func (c sineClock) Adjust(t time.Time) time.Time {
+	x := 2*math.Pi*float64(t.Sub(c.start))/float64(c.period) + c.phase
+	return t.Add(time.Duration(float64(c.amplitude) * math.Sin(x)))
+}
+
+// stepsClock: sprongen op vaste momenten.
+type stepsClock struct {
+	steps []clockStep
+}
+
+type clockStep struct {
+	at     time.Time
+	offset time.Duration
+}
+
+func (c stepsClock) Adjust(t time.Time) time.Time {
+	var d time.Duration
+	for _, s := range c.steps {
+		if t.Before(s.at) {
+			break
+		}
+		d += s.offset
+	}
+	return t.Add(d)
+}
+
+// epochClock: de hele klok verschoven, naar een vast moment of met een
+// aantal jaren.
+type epochClock struct {
+	shift time.Duration
+}
+
+// Verder dan dit past niet in een time.Duration (±292 jaar)
+const (
+	epochMinYear  = 1900
+	epochMaxYear  = 2200
+	epochMaxYears = 200
+)
+
+func validateEpoch(c ClockSpec) error {
+	switch {
+	case c.Start != "" && c.Years != 0:
+		return fmt.Errorf("start of years, niet allebei")
+	case c.Start != "":
+		t, err := time.Parse(time.RFC3339, c.Start)
+		if err != nil {
+			return fmt.Errorf("ongeldige start %q: %v", c.Start, err)
+		}
+		if t.Year() < epochMinYear || t.Year() > epochMaxYear {
+			return fmt.Errorf("start moet tussen %d en %d liggen", epochMinYear, epochMaxYear)
+		}
+	case c.Years < -epochMaxYears || c.Years > epochMaxYears:
+		return fmt.Errorf("years moet tussen -%d en %d liggen", epochMaxYears, epochMaxYears)
+	}
+	return nil
+}
+
+// Al gevalideerd
+func newEpochClock(c ClockSpec, start time.Time) epochClock {
+	if c.Start != "" {
+		at, _ := time.Parse(time.RFC3339, c.Start)
+		return epochClock{at.Sub(start)}
+	}
+	return epochClock{start.AddDate(c.Years, 0, 0).Sub(start)}
+}
+
+func (c epochClock) Adjust(t time.Time) time.Time {
+	return t.Add(c.shift)
+}
+
+// frozenClock: vanaf at blijft de klok stilstaan.
+type frozenClock struct {
+	at time.Time
+}
+
+func (c frozenClock) Adjust(t time.Time) time.Time {
+	if t.After(c.at) {
+		return c.at
+	}
+	return t
+}
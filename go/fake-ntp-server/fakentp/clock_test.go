@@ -0,0 +1,81 @@
+package fakentp_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+
+	"fake-ntp-server/fakentp"
+)
+
+// TestEpochEraRollover: vlak voor 2036-02-07T06:28:16Z staat het 32-bit
+// secondenveld bijna op 2^32 en is de v5-era 0; daarna begint het weer bij
+// 0 en is de era 1.
+func TestEpochEraRollover(t *testing.T) {
+	rollover := time.Date(2036, time.February, 7, 6, 28, 16, 0, time.UTC)
+	for _, tc := range []struct {
+		start string
+		era   uint8
+		sec   func(uint32) bool
+	}{
+		{"2036-02-07T06:28:06Z", 0, func(s uint32) bool { return s >= 0xfffffff0 }},
+		{"2036-02-07T06:28:16Z", 1, func(s uint32) bool { return s < 10 }},
+	} {
+		t.Run(tc.start, func(t *testing.T) {
+			cfg := fakentp.DefaultConfig()
+			cfg.Clock = []fakentp.ClockSpec{{Model: "epoch", Start: tc.start}}
+			cfg.NTPv5.Enabled = true
+			srv, addr, err := fakentp.Start(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+			start, _ := time.Parse(time.RFC3339, tc.start)
+
+			// v4: het transmit-secondenveld zelf
+			req := make([]byte, fakentp.NtpPacketSize)
+			req[0] = 4<<3 | 3
+			resp, err := roundTrip(addr, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sec := binary.BigEndian.Uint32(resp[40:]); !tc.sec(sec) {
+				t.Errorf("v4 transmit seconds %#x", sec)
+			}
+
+			// v5: de era staat in het antwoord
+			r, err := ntp.QueryWithOptions(addr.String(), ntp.QueryOptions{Version: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Era != tc.era {
+				t.Errorf("v5 era %d, verwacht %d", r.Era, tc.era)
+			}
+			if d := r.Time.Sub(start); d < 0 || d > 5*time.Second {
+				t.Errorf("v5 tijd %v, verwacht net na %v", r.Time, start)
+			}
+			if r.Time.Before(rollover) != (tc.era == 0) {
+				t.Errorf("v5 tijd %v aan de verkeerde kant van %v", r.Time, rollover)
+			}
+		})
+	}
+}
+
+// roundTrip stuurt één verzoek en geeft het antwoord.
+func roundTrip(addr *net.UDPAddr, req []byte) ([]byte, error) {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, fakentp.MaxPacketSize)
+	n, err := conn.Read(buf)
+	return buf[:n], err
+}
@@ -0,0 +1,182 @@
+package fakentp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Control API: een kleine HTTP/JSON-interface om de server tijdens een
+// test bij te sturen, zonder herstart (klokmodellen en scenario lopen door).
+//
+//	GET   /config                huidige basisconfig
+//	PATCH /config                gedeeltelijke config erover, bv. {"leap_indicator": 1}
+//	GET   /config/effective?client=192.0.2.1
+//	                             config zoals een client die nu krijgt
+//	POST  /step                  sprong in de klok, bv. {"offset_ms": 500}
+//	POST  /pause, POST /resume   (niet) meer antwoorden
+//	GET   /status
+//	GET   /metrics               Prometheus, zie metrics.go
+//
+// Een PATCH gaat door dezelfde validatie als config.json. Opties die alleen
+// bij het starten gelezen worden (port, workers, nts.enabled, clock,
+// upstream, leap, kernel_timestamps, interleaved, control_addr, seed)
+// hebben geen effect.
+//
+// Vanuit Go (zie Start) kan hetzelfde met SetConfig, Step, Pause en Resume.
+
+// manualClock is het laatste klokmodel: de som van de sprongen via /step.
+type manualClock struct {
+	offset atomic.Int64 // nanoseconden
+}
+
+func (c *manualClock) Adjust(t time.Time) time.Time {
+	return t.Add(time.Duration(c.offset.Load()))
+}
+
+type controlStatus struct {
+	Paused    bool    `json:"paused"`
+	StepMs    float64 `json:"step_ms"`
+	Phase     int     `json:"phase"` // -1 zonder scenario
+	PhaseName string  `json:"phase_name,omitempty"`
+	UptimeSec float64 `json:"uptime_sec"`
+	// Gefilterde offset van de systeemklok t.o.v. upstream, zie upstream.go
+	UpstreamMs *float64 `json:"upstream_offset_ms,omitempty"`
+}
+
+// SetConfig vervangt de basisconfig, net als een PATCH van de control API.
+func (s *Server) SetConfig(cfg Config) error {
+	cfg.Listeners = nil
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+	configs, err := newConfigSet(cfg.clone(), s.scenario)
+	if err != nil {
+		return err
+	}
+	s.configs.Store(configs)
+	return nil
+}
+
+// Step laat de klok van de server een sprong maken, bovenop eerdere
+// sprongen, en geeft het totaal.
+func (s *Server) Step(d time.Duration) time.Duration {
+	return time.Duration(s.manual.offset.Add(int64(d)))
+}
+
+// Pause laat de server ophouden met antwoorden; Resume laat hem weer
+// antwoorden.
+func (s *Server) Pause() {
+	s.paused.Store(true)
+}
+
+func (s *Server) Resume() {
+	s.paused.Store(false)
+}
+
+func (s *Server) startControl(addr string) error {
+	var patchMu sync.Mutex // PATCH is lezen-wijzigen-schrijven
+	started := time.Now()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.Config())
+	})
+	mux.HandleFunc("PATCH /config", func(w http.ResponseWriter, r *http.Request) {
+		raw, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		patchMu.Lock()
+		defer patchMu.Unlock()
+		cfg, err := applyOverride(s.Config(), raw)
+		if err == nil {
+			err = s.SetConfig(cfg)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Control API: config gewijzigd: %s", raw)
+		writeJSON(w, cfg)
+	})
+	mux.HandleFunc("GET /config/effective", func(w http.ResponseWriter, r *http.Request) {
+		var client netip.Addr
+		if c := r.URL.Query().Get("client"); c != "" {
+			var err error
+			if client, err = netip.ParseAddr(c); err != nil {
+				http.Error(w, "ongeldig client-adres: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, s.configFor(time.Now(), client))
+	})
+	mux.HandleFunc("POST /step", func(w http.ResponseWriter, r *http.Request) {
+		var step struct {
+			OffsetMs float64 `json:"offset_ms"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&step); err != nil {
+			http.Error(w, "verwacht {\"offset_ms\": ...}: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		total := s.Step(msDuration(step.OffsetMs))
+		log.Printf("Control API: sprong van %v ms (totaal %v)", step.OffsetMs, total)
+		writeJSON(w, s.controlStatus(started))
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		s.Pause()
+		log.Println("Control API: gepauzeerd")
+		writeJSON(w, s.controlStatus(started))
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		s.Resume()
+		log.Println("Control API: hervat")
+		writeJSON(w, s.controlStatus(started))
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.controlStatus(started))
+	})
+	mux.Handle("GET /metrics", s.metrics.handler())
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Kan control API niet starten: %v", err)
+	}
+	s.control = &http.Server{Handler: mux}
+	go s.control.Serve(ln)
+	log.Printf("Control API op http://%s/", ln.Addr())
+	return nil
+}
+
+func (s *Server) controlStatus(started time.Time) controlStatus {
+	st := controlStatus{
+		Paused:    s.paused.Load(),
+		StepMs:    float64(s.manual.offset.Load()) / float64(time.Millisecond),
+		Phase:     -1,
+		UptimeSec: time.Since(started).Seconds(),
+	}
+	if s.scenario != nil {
+		st.Phase = s.scenario.phaseAt(time.Now())
+		st.PhaseName = s.scenario.Phases[st.Phase].Name
+	}
+	if s.upstream != nil && s.upstream.synced.Load() {
+		ms := float64(s.upstream.offset.Load()) / float64(time.Millisecond)
+		st.UpstreamMs = &ms
+	}
+	return st
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
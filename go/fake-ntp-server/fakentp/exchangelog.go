@@ -0,0 +1,170 @@
+package fakentp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Exchange log: per verzoek één JSON-regel met wat de server ermee deed,
+// zodat een test daarop kan controleren. Bijvoorbeeld:
+//
+//	{"time":"...","client":"127.0.0.1","version":4,"mode":3,"action":"response",
+//	 "t1":"...","t2":"...","t3":"...","real_tx":"...",
+//	 "response":{"leap":0,"version":4,"mode":4,"stratum":1,...}}
+//
+// T4 kent alleen de client.
+
+// exchange is wat de server met één verzoek deed.
+type exchange struct {
+	client        *net.UDPAddr
+	version, mode uint8
+	t1            uint64    // transmit timestamp van de client
+	rxTime        time.Time // echte ontvangsttijd
+	txTime        time.Time // echte tijd bij het invullen van T3, of nul
+
+	action string // response, kod, nts_nak, malformed, mode6, mode7 of drop
+	reason string // drop: paused, drop, mode, rate_limit, nts; kod: de kiss code; malformed: het geval; mode6: de opcode
+	lost   bool   // verloren door impair
+	resp   []byte // zoals verstuurd
+}
+
+func newExchange(req []byte, clientAddr *net.UDPAddr, rxTime time.Time) *exchange {
+	ex := &exchange{
+		client:  clientAddr,
+		version: req[0] >> 3 & 0x07,
+		mode:    req[0] & 0x07,
+		rxTime:  rxTime,
+	}
+	if len(req) >= NtpPacketSize { // niet bij mode 6 en 7
+		ex.t1 = binary.BigEndian.Uint64(req[40:48])
+	}
+	return ex
+}
+
+// exchangeEntry is een regel in de exchange log.
+type exchangeEntry struct {
+	Time     string `json:"time"` // ontvangst, echte tijd
+	Listener string `json:"listener,omitempty"`
+	Client   string `json:"client"`
+	Version  int    `json:"version"`
+	Mode     int    `json:"mode"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason,omitempty"`
+	Lost     bool   `json:"lost,omitempty"`
+
+	T1     string `json:"t1,omitempty"`      // transmit timestamp van de client
+	T2     string `json:"t2,omitempty"`      // receive timestamp zoals verstuurd
+	T3     string `json:"t3,omitempty"`      // transmit timestamp zoals verstuurd
+	RealTx string `json:"real_tx,omitempty"` // echte tijd bij het invullen van T3
+
+	Response *sentFields `json:"response,omitempty"`
+}
+
+// sentFields zijn de velden van het verstuurde antwoord.
+type sentFields struct {
+	Length         int     `json:"length"`
+	Leap           int     `json:"leap"`
+	Version        int     `json:"version"`
+	Mode           int     `json:"mode"`
+	Stratum        int     `json:"stratum"`
+	Poll           int     `json:"poll"`
+	Precision      int     `json:"precision"`
+	RootDelay      float64 `json:"root_delay"`      // seconden
+	RootDispersion float64 `json:"root_dispersion"` // seconden
+	RefID          string  `json:"ref_id,omitempty"`
+	RefTime        string  `json:"ref_time,omitempty"`
+	Origin         string  `json:"origin,omitempty"`
+	Timescale      *int    `json:"timescale,omitempty"` // NTPv5
+	Era            *int    `json:"era,omitempty"`       // NTPv5
+	Flags          *int    `json:"flags,omitempty"`     // NTPv5
+}
+
+func (ex *exchange) entry(listener string) exchangeEntry {
+	e := exchangeEntry{
+		Time:     ex.rxTime.UTC().Format(time.RFC3339Nano),
+		Listener: listener,
+		Client:   addrOf(ex.client).String(),
+		Version:  int(ex.version),
+		Mode:     int(ex.mode),
+		Action:   ex.action,
+		Reason:   ex.reason,
+		Lost:     ex.lost,
+		T1:       ntpTimestampString(ex.t1),
+	}
+	if !ex.txTime.IsZero() {
+		e.RealTx = ex.txTime.UTC().Format(time.RFC3339Nano)
+	}
+	resp := ex.resp
+	if resp == nil || ex.mode == 6 || ex.mode == 7 {
+		return e // geen NTP-pakket
+	}
+	f := &sentFields{Length: len(resp)}
+	e.Response = f
+	if len(resp) < NtpPacketSize {
+		return e
+	}
+	f.Leap = int(resp[0] >> 6)
+	f.Version = int(resp[0] >> 3 & 0x07)
+	f.Mode = int(resp[0] & 0x07)
+	f.Stratum = int(resp[1])
+	f.Poll = int(int8(resp[2]))
+	f.Precision = int(int8(resp[3]))
+	e.T2 = ntpTimestampString(binary.BigEndian.Uint64(resp[32:]))
+	e.T3 = ntpTimestampString(binary.BigEndian.Uint64(resp[40:]))
+
+	delay, disp := binary.BigEndian.Uint32(resp[4:]), binary.BigEndian.Uint32(resp[8:])
+	if f.Version == 5 {
+		// Q4.28, en geen refid, reference time of origin
+		f.RootDelay = float64(delay) / (1 << 28)
+		f.RootDispersion = float64(disp) / (1 << 28)
+		ts, era, flags := int(resp[12]), int(resp[13]), int(binary.BigEndian.Uint16(resp[14:]))
+		f.Timescale, f.Era, f.Flags = &ts, &era, &flags
+		return e
+	}
+	f.RootDelay = shortToDuration(delay).Seconds()
+	f.RootDispersion = shortToDuration(disp).Seconds()
+	f.RefID = hex.EncodeToString(resp[12:16])
+	f.RefTime = ntpTimestampString(binary.BigEndian.Uint64(resp[16:]))
+	f.Origin = ntpTimestampString(binary.BigEndian.Uint64(resp[24:]))
+	return e
+}
+
+func ntpTimestampString(ts uint64) string {
+	if ts == 0 {
+		return ""
+	}
+	return ntpToTime(ts).UTC().Format(time.RFC3339Nano)
+}
+
+// exchangeLog schrijft de regels, voor alle listeners samen.
+type exchangeLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// openExchangeLog opent path om aan toe te voegen; "-" is stdout.
+func openExchangeLog(path string) (*exchangeLog, error) {
+	f := os.Stdout
+	if path != "-" {
+		var err error
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &exchangeLog{enc: json.NewEncoder(f)}, nil
+}
+
+func (l *exchangeLog) write(e exchangeEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil {
+		log.Printf("Fout bij schrijven exchange log: %v", err)
+	}
+}
@@ -0,0 +1,1001 @@
+// Package fakentp is de fake NTP-server als package, zodat Go-tests hem in
+// hetzelfde proces kunnen starten: op een vrije poort op 127.0.0.1, zonder
+// apart proces en zonder rechten voor poort 123.
+//
+//	cfg := fakentp.DefaultConfig()
+//	cfg.TimeOffsetMs = 500
+//	srv, addr, err := fakentp.Start(cfg)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer srv.Close()
+//	resp, err := ntp.Query(addr.String())
+//
+// Tijdens de test is het gedrag bij te sturen met SetConfig, Step, Pause en
+// Resume. Het programma fake-ntpd (zie README.md) is Run.
+package fakentp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NTP constants
+const (
+	NtpEpochOffset = 2208988800 // Offset between NTP epoch (1 Jan 1900) and Unix epoch (1 Jan 1970) in seconds
+	NtpPacketSize  = 48         // Standard NTP packet size in bytes
+	MaxPacketSize  = 2048       // NTP packet with extension fields (NTS)
+)
+
+type Config struct {
+	Address          string `json:"address"` // standaard 0.0.0.0; "::" of "::1" voor IPv6
+	Port             int    `json:"port"`
+	Debug            bool   `json:"debug"`
+	MinPoll          int    `json:"min_poll"`
+	MaxPoll          int    `json:"max_poll"`
+	MinPrecision     int    `json:"min_precision"`
+	MaxPrecision     int    `json:"max_precision"`
+	MaxRefTimeOffset int64  `json:"max_ref_time_offset"`
+	RefIDType        string `json:"ref_id_type"`
+	MinStratum       int    `json:"min_stratum"`
+	MaxStratum       int    `json:"max_stratum"`
+	LeapIndicator    int    `json:"leap_indicator"`
+	VersionNumber    int    `json:"version_number"`
+
+	// Nieuw: rx time offset in milliseconden. Positief = aftrekken van rxTime,
+	// negatief = toevoegen. Voorbeeld: 10 -> rxTime = rxTime - 10ms.
+	TimeOffsetMs int `json:"time_offset_ms"`
+
+	// Willekeurige afwijking per antwoord, tussen -jitter_ms en +jitter_ms
+	// (voor rx en tx dezelfde)
+	JitterMs int `json:"jitter_ms"`
+
+	// Vaste seed voor alle willekeur (stratum, poll, precision, refid,
+	// jitter, verstoringen); zonder seed kiest de server er een en logt
+	// die. Alleen bij het starten; zie random.go
+	Seed *int64 `json:"seed"`
+
+	// Klokmodellen voor de hele server, zie clock.go
+	Clock []ClockSpec `json:"clock"`
+
+	// De tijd van echte NTP-servers als basis in plaats van de systeemklok;
+	// zie upstream.go. Alleen bij het starten
+	Upstream UpstreamConfig `json:"upstream"`
+
+	// Root delay, root dispersion, refid en reference timestamp van een
+	// gesimuleerde upstream-hiërarchie, zie hierarchy.go
+	Hierarchy HierarchyConfig `json:"hierarchy"`
+
+	// Drift-opties van de vroegere fake-ntp-server-2; zonder "clock"
+	// worden die omgezet naar een klokmodel. Alleen "none" en "random_walk".
+	DriftModel     string  `json:"drift_model"`
+	DriftPPM       float64 `json:"drift_ppm"`
+	DriftStepPPM   float64 `json:"drift_step_ppm"`
+	DriftUpdateSec int     `json:"drift_update_interval_sec"`
+
+	// Rate limiting per client (token bucket): gemiddeld rate_limit verzoeken
+	// per seconde, met bursts tot rate_burst. Daarboven volgt een KoD RATE.
+	// 0 = geen limiet.
+	RateLimit float64 `json:"rate_limit"`
+	RateBurst int     `json:"rate_burst"`
+
+	// Ontvangsttijd (T2) uit de kernel halen in plaats van na het lezen in
+	// userspace (alleen Linux)
+	KernelTimestamps bool `json:"kernel_timestamps"`
+
+	// Aantal goroutines dat verzoeken afhandelt; 0 = aantal CPU's
+	Workers int `json:"workers"`
+
+	// Adres voor de HTTP control API, bv. "127.0.0.1:8123"; leeg = uit.
+	// Zie control.go.
+	ControlAddr string `json:"control_addr"`
+
+	// Adres voor alleen /metrics (Prometheus), bv. ":9123"; leeg = uit. De
+	// control API heeft ook /metrics. Zie metrics.go
+	MetricsAddr string `json:"metrics_addr"`
+
+	// Elk verzoek als JSON-regel naar dit bestand ("-" = stdout), zie
+	// exchangelog.go
+	ExchangeLog string `json:"exchange_log"`
+
+	// Geen antwoord sturen (verzoek stilletjes laten vallen)
+	Drop bool `json:"drop"`
+
+	// Netwerkverstoringen: verlies, duplicaten en vertraging, zie impair.go
+	Impair ImpairConfig `json:"impair"`
+
+	// Opzettelijk kapotte antwoorden, per antwoord een van deze gevallen;
+	// zie malform.go
+	Malform []string `json:"malform"`
+
+	// Symmetric active peers (mode 1) beantwoorden met mode 2, en
+	// broadcast-pakketten (mode 5) sturen; zie modes.go
+	Symmetric bool            `json:"symmetric"`
+	Broadcast BroadcastConfig `json:"broadcast"`
+
+	// Control-verzoeken (ntpq) en monlist (ntpdc); zie mode6.go en mode7.go
+	Mode6 Mode6Config `json:"mode6"`
+	Mode7 Mode7Config `json:"mode7"`
+
+	// Interleaved mode (RFC 9769): per client het vorige antwoord
+	// onthouden en op verzoek diens precieze transmit timestamp sturen
+	Interleaved bool `json:"interleaved"`
+
+	// Leap second (uit leap-seconds.list of op een gekozen moment), zie leap.go
+	Leap LeapConfig    `json:"leap"`
+	leap *leapSchedule // door de server ingevuld
+
+	// Per verzoek door de server ingevuld
+	clock   []ClockModel
+	jitter  time.Duration
+	replay  *replayRecord
+	rand    *lockedRand
+	started time.Time
+
+	// NTS-KE en NTS-beveiligde NTP, zie nts.go
+	NTS NTSConfig `json:"nts"`
+
+	// Antwoorden in NTPv5-formaat op v5-verzoeken, zie ntpv5.go
+	NTPv5 NTPv5Config `json:"ntpv5"`
+
+	// Afwijkende instellingen per client-adres of -prefix, zie clients.go
+	Clients []ClientProfile `json:"clients"`
+
+	// Meerdere servers in één proces, elk met een eigen adres en poort; zie
+	// listeners.go
+	Listeners []Listener `json:"listeners"`
+}
+
+type NTPPacket struct {
+	Settings     uint8
+	Stratum      uint8
+	Poll         int8
+	Precision    int8
+	RootDelay    uint32
+	RootDisp     uint32
+	RefID        uint32
+	RefTimeSec   uint32
+	RefTimeFrac  uint32
+	OrigTimeSec  uint32
+	OrigTimeFrac uint32
+	RxTimeSec    uint32
+	RxTimeFrac   uint32
+	TxTimeSec    uint32
+	TxTimeFrac   uint32
+}
+
+func loadConfig(path string) (Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("Kan configbestand niet openen: %v", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	var config Config
+	err = decoder.Decode(&config)
+	if err != nil {
+		return Config{}, fmt.Errorf("Fout bij inlezen configbestand: %v", err)
+	}
+
+	if err := validateConfig(config); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+func validateConfig(config Config) error {
+	if config.LeapIndicator < 0 || config.LeapIndicator > 3 {
+		return fmt.Errorf("Ongeldige leap-indicator: %d (moet 0–3 zijn)", config.LeapIndicator)
+	}
+	if config.VersionNumber < 1 || config.VersionNumber > 7 {
+		return fmt.Errorf("Ongeldig version number: %d (moet 1–7 zijn)", config.VersionNumber)
+	}
+	if config.MinStratum < 0 || config.MaxStratum > 16 || config.MinStratum > config.MaxStratum {
+		return fmt.Errorf("Ongeldige stratum-range: %d-%d (moet 0–16 en min<=max)", config.MinStratum, config.MaxStratum)
+	}
+	if config.MinPrecision > config.MaxPrecision {
+		return fmt.Errorf("Ongeldige precision-range: %d-%d (min moet <= max)", config.MinPrecision, config.MaxPrecision)
+	}
+	if config.MinPoll > config.MaxPoll {
+		return fmt.Errorf("Ongeldige poll-range: %d-%d (min moet <= max)", config.MinPoll, config.MaxPoll)
+	}
+	if config.RateLimit < 0 || config.RateBurst < 0 {
+		return fmt.Errorf("Ongeldige rate limit: %v/s, burst %d (moet >= 0 zijn)", config.RateLimit, config.RateBurst)
+	}
+	if config.JitterMs < 0 {
+		return fmt.Errorf("Ongeldige jitter: %d ms (moet >= 0 zijn)", config.JitterMs)
+	}
+	switch config.DriftModel {
+	case "", "none", "random_walk":
+	default:
+		return fmt.Errorf("Onbekend drift_model %q (none of random_walk; zie ook clock)", config.DriftModel)
+	}
+	if err := validateClock(config.Clock); err != nil {
+		return err
+	}
+	if err := validateUpstream(config.Upstream); err != nil {
+		return err
+	}
+	if err := validateHierarchy(config.Hierarchy); err != nil {
+		return err
+	}
+	if err := validateLeap(config.Leap); err != nil {
+		return err
+	}
+	if err := validateNTPv5(config.NTPv5); err != nil {
+		return err
+	}
+	if err := validateImpair(config.Impair); err != nil {
+		return err
+	}
+	if err := validateMalform(config.Malform); err != nil {
+		return err
+	}
+	if err := validateBroadcast(config.Broadcast); err != nil {
+		return err
+	}
+	if err := validateMode6(config.Mode6); err != nil {
+		return err
+	}
+	if err := validateMode7(config.Mode7); err != nil {
+		return err
+	}
+	for i, p := range config.Clients {
+		base := config
+		base.Clients = nil // profielen niet opnieuw valideren
+		clientCfg, err := applyOverride(base, p.Config)
+		if err == nil {
+			err = validateConfig(clientCfg)
+		}
+		if err != nil {
+			return fmt.Errorf("Client-profiel %d (%v): %v", i, p.Match, err)
+		}
+	}
+	if _, err := listenerConfigs(config); err != nil {
+		return err
+	}
+	return nil
+}
+
+func refIDFromType(refid string, strat uint8, r *lockedRand) uint32 {
+	// XFUN, DENY, INIT, STEP, RATE etc.
+	// Zelf zorgen voor de juiste RFC5905 match - of niet ;-)
+	switch strat {
+	case 0, 1, 16:
+		// Korter dan 4 tekens (bv. "GPS") aanvullen met nullen
+		var b [4]byte
+		copy(b[:], refid)
+		return binary.BigEndian.Uint32(b[:])
+	default:
+		return r.Uint32()
+	}
+}
+
+// ntpTimestampParts geeft de NTP-timestamp van t. Na 7 februari 2036
+// (era 1) begint sec weer bij 0: de uint32 telt modulo 2^32.
+func ntpTimestampParts(t time.Time) (sec uint32, frac uint32) {
+	unixSecs := t.Unix()
+	nanos := t.Nanosecond()
+	fracSecs := float64(nanos) / 1e9
+	sec = uint32(unixSecs + NtpEpochOffset)
+	frac = uint32(fracSecs * math.Pow(2, 32))
+	return
+}
+
+func parseClientInfo(req []byte) (version uint8, mode uint8, txSec uint32, txFrac uint32) {
+	settings := req[0]
+	version = (settings >> 3) & 0x07
+	mode = settings & 0x07
+	txSec = binary.BigEndian.Uint32(req[40:44])
+	txFrac = binary.BigEndian.Uint32(req[44:48])
+	return
+}
+
+func createFakeNTPResponse(req []byte, cfg Config, nowRx time.Time) []byte {
+
+	rxTime := servedTime(cfg, nowRx)
+
+	refOffset := cfg.MaxRefTimeOffset // refOffset := rand.Int63n(cfg.MaxRefTimeOffset)
+	refTime := rxTime.Add(-time.Duration(refOffset) * time.Second)
+	refSec, refFrac := ntpTimestampParts(refTime)
+	//rxTime := rxTime.Add(-time.Duration(rand.Intn(5)+1) * time.Millisecond) // Simuleer ontvangstmoment iets eerder (1–5 ms) - untested
+	//rxTime := now.Add(-time.Duration(rand.Intn(5)+1) * time.Millisecond) // Simuleer ontvangstmoment iets eerder (1–5 ms)
+	rxSec, rxFrac := ntpTimestampParts(rxTime)
+
+	li := uint8(cfg.LeapIndicator & 0x03)
+	vn := uint8(cfg.VersionNumber & 0x07)
+	mode := uint8(4)
+	settings := (li << 6) | (vn << 3) | mode
+
+	precisionRange := cfg.MaxPrecision - cfg.MinPrecision + 1
+	precision := int8(cfg.rand.Intn(precisionRange) + cfg.MinPrecision)
+
+	pollRange := cfg.MaxPoll - cfg.MinPoll + 1
+	poll := int8(cfg.rand.Intn(pollRange) + cfg.MinPoll)
+
+	stratumRand := uint8(cfg.rand.Intn(cfg.MaxStratum-cfg.MinStratum+1) + cfg.MinStratum)
+	rootRand := stratumRand
+	if stratumRand == 0 {
+		rootRand = 1
+	}
+
+	packet := NTPPacket{
+		Settings:     settings,
+		Stratum:      stratumRand,
+		Poll:         poll,
+		Precision:    precision,
+		RootDelay:    100 * (uint32(rootRand) - 1), // RootDelay:    rand.Uint32(),
+		RootDisp:     200 * (uint32(rootRand) - 1), // RootDisp:     rand.Uint32(),
+		RefID:        refIDFromType(cfg.RefIDType, stratumRand, cfg.rand),
+		RefTimeSec:   refSec,
+		RefTimeFrac:  refFrac,
+		OrigTimeSec:  binary.BigEndian.Uint32(req[40:44]),
+		OrigTimeFrac: binary.BigEndian.Uint32(req[44:48]),
+		RxTimeSec:    rxSec,
+		RxTimeFrac:   rxFrac,
+		// TxTime wordt pas vlak voor verzenden ingevuld, zie stampTransmitTime
+	}
+	if h, ok := hierarchyRoot(cfg, stratumRand, nowRx); ok {
+		packet.RootDelay = durationToShort(h.delay)
+		packet.RootDisp = durationToShort(h.disp)
+		packet.RefID = h.refID
+		packet.RefTimeSec, packet.RefTimeFrac = ntpTimestampParts(h.refTime)
+	}
+	if cfg.replay != nil {
+		// Precies de waarden van de opgenomen server
+		packet.RefID = cfg.replay.refID
+		packet.RootDelay = durationToShort(cfg.replay.rootDelay)
+		packet.RootDisp = durationToShort(cfg.replay.rootDisp)
+	}
+
+	buf := make([]byte, NtpPacketSize)
+	buf[0] = packet.Settings
+	buf[1] = packet.Stratum
+	buf[2] = byte(packet.Poll)
+	buf[3] = byte(packet.Precision)
+	binary.BigEndian.PutUint32(buf[4:], packet.RootDelay)
+	binary.BigEndian.PutUint32(buf[8:], packet.RootDisp)
+	binary.BigEndian.PutUint32(buf[12:], packet.RefID)
+	binary.BigEndian.PutUint32(buf[16:], packet.RefTimeSec)
+	binary.BigEndian.PutUint32(buf[20:], packet.RefTimeFrac)
+	binary.BigEndian.PutUint32(buf[24:], packet.OrigTimeSec)
+	binary.BigEndian.PutUint32(buf[28:], packet.OrigTimeFrac)
+	binary.BigEndian.PutUint32(buf[32:], packet.RxTimeSec)
+	binary.BigEndian.PutUint32(buf[36:], packet.RxTimeFrac)
+	binary.BigEndian.PutUint32(buf[40:], packet.TxTimeSec)
+	binary.BigEndian.PutUint32(buf[44:], packet.TxTimeFrac)
+
+	return buf
+}
+
+// servedTime zet de echte tijd t om naar de tijd die de server uitzendt:
+// eerst de klokmodellen, dan de configureerbare offset (standaard wordt er
+// *afgetrokken*; bij een negatief cfg.TimeOffsetMs wordt er toegevoegd),
+// een eventuele leap second en de jitter van dit verzoek.
+func servedTime(cfg Config, t time.Time) time.Time {
+	served := clockTime(cfg, t)
+	if cfg.leap != nil {
+		served = served.Add(cfg.leap.shift(served))
+	}
+	return served.Add(cfg.jitter)
+}
+
+// clockTime is servedTime zonder leap second en jitter. Daarop loopt het
+// leap-second-schema, zodat dat ook met een epoch-klokmodel klopt.
+func clockTime(cfg Config, t time.Time) time.Time {
+	for _, m := range cfg.clock {
+		t = m.Adjust(t)
+	}
+	if cfg.replay != nil {
+		t = t.Add(cfg.replay.offset)
+	}
+	if cfg.TimeOffsetMs != 0 {
+		t = t.Add(-time.Duration(cfg.TimeOffsetMs) * time.Millisecond)
+	}
+	return t
+}
+
+// stampTransmitTime vult de transmit timestamp in, zo laat mogelijk: vlak
+// voor het versturen (of, bij NTS, vlak voor het versleutelen). Geeft de
+// echte tijd en de ingevulde tijd terug.
+func stampTransmitTime(resp []byte, cfg Config) (time.Time, time.Time) {
+	//time.Sleep(1 * time.Second)
+	// De nowTx zo laat mogelijk
+	now := time.Now()
+	nowTx := servedTime(cfg, now)
+	//nowTx := time.Date(2040, time.February, 10, 12, 0, 0, 0, time.UTC)
+	//nowTx := time.Now().AddDate(20, 0, 0) // 20 jaar erbij
+	//nowTx := time.Now().Add(1 * time.Hour)
+	// zie ook nowRx
+
+	txSec, txFrac := ntpTimestampParts(nowTx)
+	binary.BigEndian.PutUint32(resp[40:], txSec)
+	binary.BigEndian.PutUint32(resp[44:], txFrac)
+	return now, nowTx
+}
+
+// Server is één fake NTP-server en bundelt de gedeelde toestand. Meerdere
+// workers lezen tegelijk van dezelfde socket; alles wat ze delen is
+// read-only of zelf thread-safe.
+type Server struct {
+	name        string
+	conn        *net.UDPConn
+	configs     atomic.Pointer[configSet] // te wijzigen via de control API of SetConfig
+	scenario    *Scenario
+	activePhase atomic.Int64
+	limiter     *rateLimiter
+	nts         *ntsServer
+	kernelRx    bool // ontvangsttijd van de kernel (SO_TIMESTAMPNS)
+	interleaved *interleavedState
+	refIDv5     [refIDv5Size]byte // eigen NTPv5 reference ID
+	leap        *leapSchedule
+	clock       []ClockModel
+	upstream    *upstreamClock // eerste in clock, als upstream.servers gezet is
+	replay      *replay
+	manual      *manualClock // sprongen via de control API of Step
+	paused      atomic.Bool
+	burst       burstLoss // toestand van het Gilbert-Elliott-model
+	xlog        *exchangeLog
+	metrics     *metrics
+	monitor     *monitor    // recente clients, voor monlist
+	rand        *lockedRand // voor de verzoeken, zie random.go
+	started     time.Time
+
+	control     *http.Server // control API, als control_addr gezet is
+	offsetGauge prometheus.Collector
+	done        chan struct{} // dicht na Close
+	closeOnce   sync.Once
+}
+
+const timeFormat = "2006-01-02 15:04:05 MST"
+
+// configSet is de basisconfig met alles wat er per verzoek overheen kan
+// komen al uitgerekend: de scenario-fases en de client-profielen. Een
+// verzoek hoeft dan alleen te kiezen.
+type configSet struct {
+	base   resolvedConfig
+	phases []resolvedConfig // per scenario-fase
+}
+
+// resolvedConfig is een config plus die config met elk van zijn
+// client-profielen eroverheen.
+type resolvedConfig struct {
+	cfg     Config
+	clients []Config
+}
+
+func newConfigSet(base Config, sc *Scenario) (*configSet, error) {
+	set := &configSet{}
+	var err error
+	if set.base, err = resolveClients(base); err != nil {
+		return nil, err
+	}
+	if sc == nil {
+		return set, nil
+	}
+	phases, err := sc.resolve(base)
+	if err != nil {
+		return nil, err
+	}
+	for i, cfg := range phases {
+		r, err := resolveClients(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("Fase %d (%q): %v", i, sc.Phases[i].Name, err)
+		}
+		set.phases = append(set.phases, r)
+	}
+	return set, nil
+}
+
+func resolveClients(cfg Config) (resolvedConfig, error) {
+	r := resolvedConfig{cfg: cfg}
+	for i, p := range cfg.Clients {
+		clientCfg, err := applyOverride(cfg, p.Config)
+		if err != nil {
+			return r, fmt.Errorf("Client-profiel %d (%v): %v", i, p.Match, err)
+		}
+		r.clients = append(r.clients, clientCfg)
+	}
+	return r, nil
+}
+
+// Config geeft de huidige basisconfig.
+func (s *Server) Config() Config {
+	return s.configs.Load().base.cfg
+}
+
+// configFor geeft de config voor een verzoek: de basisconfig, de actieve
+// scenario-fase daaroverheen, en dan een eventueel client-profiel.
+func (s *Server) configFor(now time.Time, clientIP netip.Addr) Config {
+	set := s.configs.Load()
+	r := &set.base
+	if s.scenario != nil {
+		phase := s.scenario.phaseAt(now)
+		r = &set.phases[phase]
+		if old := s.activePhase.Swap(int64(phase)); old != int64(phase) && set.base.cfg.Debug {
+			fmt.Printf("Scenario: fase %d (%q) actief\n", phase, s.scenario.Phases[phase].Name)
+		}
+	}
+	reqCfg := r.cfg
+	if i := matchClient(reqCfg.Clients, clientIP); i >= 0 {
+		reqCfg = r.clients[i]
+	}
+	if s.replay != nil {
+		rec := s.replay.at(now)
+		rec.apply(&reqCfg)
+	}
+	reqCfg.clock = s.clock
+	reqCfg.rand = s.rand
+	reqCfg.started = s.started
+	if s.leap != nil {
+		reqCfg.leap = s.leap
+		// Een expliciete leap indicator (bv. 3) gaat voor
+		if reqCfg.LeapIndicator == 0 {
+			reqCfg.LeapIndicator = s.leap.indicator(clockTime(reqCfg, now))
+		}
+	}
+	return reqCfg
+}
+
+// drawJitter kiest de jitter voor één antwoord. Niet in configFor, zodat
+// /metrics en de control API de reeks willekeurige getallen niet verstoren.
+func (c *Config) drawJitter() {
+	if c.JitterMs > 0 {
+		c.jitter = time.Duration(c.rand.Intn(c.JitterMs*2+1)-c.JitterMs) * time.Millisecond
+	}
+}
+
+// serve is één worker: lezen, antwoorden, en weer lezen.
+func (s *Server) serve() {
+	buf := make([]byte, MaxPacketSize)
+	var oob []byte
+	if s.kernelRx {
+		oob = make([]byte, 128)
+	}
+	for {
+		n, oobn, _, clientAddr, err := s.conn.ReadMsgUDP(buf, oob)
+		// Zo vroeg mogelijk, per verzoek; liever nog de tijd van de kernel
+		rxTime := time.Now()
+		if s.kernelRx {
+			if t, ok := rxTimestamp(oob[:oobn]); ok {
+				rxTime = t
+			}
+		}
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		// Mode 6- en 7-verzoeken zijn korter dan een NTP-pakket
+		if n < NtpPacketSize && !(n > 0 && (buf[0]&0x07 == 6 || buf[0]&0x07 == 7)) {
+			continue
+		}
+		s.handle(buf[:n], clientAddr, rxTime)
+	}
+}
+
+func (s *Server) handle(req []byte, clientAddr *net.UDPAddr, rxTime time.Time) {
+	cfg := s.Config()
+	clientIP := addrOf(clientAddr)
+	if s.monitor != nil {
+		s.monitor.seen(clientAddr, req, rxTime)
+	}
+	if s.paused.Load() {
+		if cfg.Debug {
+			fmt.Printf("Verzoek van %s genegeerd (gepauzeerd)\n", clientAddr.IP.String())
+		}
+		s.recordDrop(newExchange(req, clientAddr, rxTime), "paused")
+		return
+	}
+	reqCfg := s.configFor(rxTime, clientIP)
+	if reqCfg.Drop {
+		if cfg.Debug {
+			fmt.Printf("Verzoek van %s genegeerd (drop)\n", clientAddr.IP.String())
+		}
+		s.recordDrop(newExchange(req, clientAddr, rxTime), "drop")
+		return
+	}
+	reqCfg.drawJitter()
+
+	if d := reqCfg.Impair.RequestDelay.draw(reqCfg.rand); d > 0 {
+		// Vertraging op de heenweg: het verzoek komt later binnen, en
+		// dus is ook T2 later. De buffer van de worker wordt hergebruikt.
+		req = bytes.Clone(req)
+		time.AfterFunc(d, func() {
+			s.respond(req, clientAddr, reqCfg, time.Now())
+		})
+		return
+	}
+	s.respond(req, clientAddr, reqCfg, rxTime)
+}
+
+// respond beantwoordt een verzoek dat (eventueel na vertraging) binnen is.
+func (s *Server) respond(req []byte, clientAddr *net.UDPAddr, reqCfg Config, rxTime time.Time) {
+	cfg := s.Config()
+	clientIP := addrOf(clientAddr)
+	ex := newExchange(req, clientAddr, rxTime)
+
+	switch req[0] & 0x07 {
+	case 6:
+		if reqCfg.Mode6.Enabled {
+			s.respondMode6(ex, req, clientAddr, reqCfg)
+			return
+		}
+	case 7:
+		if reqCfg.Mode7.Monlist {
+			s.respondMode7(ex, req, clientAddr, reqCfg)
+			return
+		}
+	}
+	if len(req) < NtpPacketSize {
+		s.recordDrop(ex, "mode")
+		return
+	}
+
+	version, mode, txSec, txFrac := parseClientInfo(req)
+	symmetric := mode == 1 && reqCfg.Symmetric
+	if mode != 3 && !symmetric {
+		if cfg.Debug {
+			fmt.Printf("Genegeerd verzoek van %s met mode %d\n", clientAddr.IP.String(), mode)
+		}
+		s.recordDrop(ex, "mode")
+		return
+	}
+
+	if cfg.Debug {
+		txTime := ntpToTime(uint64(txSec)<<32 | uint64(txFrac)).UTC().Format(timeFormat)
+		fmt.Printf("Verzoek van %s\n  - NTP versie: %d\n  - Client transmit timestamp: %s\n",
+			clientAddr.IP.String(), version, txTime)
+		if s.name != "" {
+			fmt.Printf("  - Listener: %s\n", s.name)
+		}
+		if symmetric {
+			fmt.Println("  - Symmetric active peer, antwoord in mode 2")
+		}
+	}
+
+	if version == 5 && reqCfg.NTPv5.Enabled && !symmetric {
+		// Geen KoD in NTPv5: boven de limiet volgt geen antwoord
+		if reqCfg.RateLimit > 0 && !s.limiter.allow(clientIP, rxTime, reqCfg.RateLimit, reqCfg.RateBurst) {
+			if cfg.Debug {
+				fmt.Printf("  - Te snel: geen NTPv5-antwoord naar %s\n", clientAddr.IP.String())
+			}
+			s.recordDrop(ex, "rate_limit")
+			return
+		}
+		s.handleV5(req, clientAddr, clientIP, reqCfg, ex)
+		return
+	}
+
+	// NTS extension fields? Zonder geldig cookie of authenticator
+	// volgt een NTS NAK.
+	var ntsReq *ntsRequest
+	if s.nts != nil && len(req) > NtpPacketSize && !symmetric {
+		var err error
+		ntsReq, err = s.nts.parseNTSRequest(req)
+		if err == nil && ntsReq != nil && reqCfg.NTS.FaultNTPNAK {
+			err = errors.New("fault_ntp_nak: NTS NAK gestuurd")
+		}
+		if err != nil {
+			if cfg.Debug {
+				fmt.Printf("  - %v\n", err)
+			}
+			if ntsReq == nil {
+				s.recordDrop(ex, "nts")
+				return
+			}
+			ex.action, ex.reason, ex.resp = "nts_nak", "NTSN", ntsNAKResponse(req, ntsReq.uid)
+			ex.lost, _ = s.send(ex.resp, clientAddr, reqCfg)
+			s.record(ex)
+			return
+		}
+	}
+
+	var resp []byte
+	var txTime, txServed time.Time
+	if reqCfg.RateLimit > 0 && !s.limiter.allow(clientIP, rxTime, reqCfg.RateLimit, reqCfg.RateBurst) {
+		if cfg.Debug {
+			fmt.Printf("  - Te snel: KoD RATE naar %s\n", clientAddr.IP.String())
+		}
+		resp = createKoDResponse(req, "RATE")
+		ex.action, ex.reason = "kod", "RATE"
+	} else {
+		ex.action = "response"
+		resp = createFakeNTPResponse(req, reqCfg, rxTime)
+		txTime, txServed = stampTransmitTime(resp, reqCfg)
+		if s.interleaved != nil && reqCfg.Interleaved && !symmetric {
+			s.interleave(req, resp, clientIP)
+		}
+	}
+	if symmetric {
+		resp[0] = resp[0]&^0x07 | 2
+	}
+	if s.nts != nil && ntsReq != nil {
+		var err error
+		if resp, err = s.nts.wrapNTSResponse(ntsReq, resp, reqCfg.NTS); err != nil {
+			log.Printf("NTS: geen antwoord naar %s: %v", clientAddr.IP.String(), err)
+			s.recordDrop(ex, "nts")
+			return
+		}
+	}
+	ex.txTime = txTime
+	if len(reqCfg.Malform) > 0 {
+		s.sendMalformed(ex, resp, clientAddr, reqCfg)
+		return
+	}
+
+	lost, err := s.send(resp, clientAddr, reqCfg)
+	if err != nil && cfg.Debug {
+		log.Printf("Fout bij versturen: %v", err)
+	}
+	if err == nil && !txTime.IsZero() && s.interleaved != nil && reqCfg.Interleaved && !symmetric {
+		s.interleaved.sent(clientIP, resp, txTime, txServed)
+	}
+	ex.resp, ex.lost = resp, lost
+	s.record(ex)
+}
+
+// interleave maakt van een basic antwoord een interleaved antwoord als de
+// client daarom vraagt: origin wordt de receive timestamp uit het verzoek en
+// transmit de precieze T3 van ons vorige antwoord. De T3 van dit antwoord
+// blijft bewaard (zie sent) voor het volgende verzoek.
+func (s *Server) interleave(req, resp []byte, clientIP netip.Addr) {
+	prevTx, ok := s.interleaved.previousTx(clientIP, binary.BigEndian.Uint64(req[24:32]))
+	if !ok {
+		return
+	}
+	copy(resp[24:32], req[32:40])
+	txSec, txFrac := ntpTimestampParts(prevTx)
+	binary.BigEndian.PutUint32(resp[40:], txSec)
+	binary.BigEndian.PutUint32(resp[44:], txFrac)
+	if s.Config().Debug {
+		fmt.Printf("  - Interleaved antwoord, vorige T3: %s\n", prevTx.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+// startServer zet een server op volgens cfg en start de workers.
+// Scenario, replay, exchange log en metrics zijn gedeeld tussen listeners.
+func startServer(cfg Config, name string, scenario *Scenario, rp *replay, xlog *exchangeLog, m *metrics) (*Server, error) {
+	host := cfg.Address
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(cfg.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("Ongeldig adres %q: %v", host, err)
+	}
+	// 0.0.0.0 alleen IPv4 en :: alleen IPv6, zodat beide naast elkaar kunnen
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("Kan niet luisteren op UDP %v: %v", addr, err)
+	}
+	// Bij poort 0 de poort die het systeem gekozen heeft
+	addr = conn.LocalAddr().(*net.UDPAddr)
+	cfg.Port = addr.Port
+
+	srv := &Server{
+		name:     name,
+		conn:     conn,
+		limiter:  newRateLimiter(),
+		scenario: scenario,
+		replay:   rp,
+		xlog:     xlog,
+		metrics:  m,
+		manual:   &manualClock{},
+		done:     make(chan struct{}),
+		started:  time.Now(),
+	}
+	configs, err := newConfigSet(cfg, scenario)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	srv.configs.Store(configs)
+	srv.activePhase.Store(-1)
+	srv.registerOffsetMetric()
+
+	// Uit de seed volgen aparte bronnen voor de verzoeken, de NTPv5
+	// reference ID en elk random_walk-klokmodel
+	seed := time.Now().UnixNano()
+	if cfg.Seed != nil {
+		seed = *cfg.Seed
+	}
+	log.Printf("Seed: %d", seed)
+	seeds := rand.New(rand.NewSource(seed))
+	srv.rand = newLockedRand(seeds.Int63())
+	srv.refIDv5 = randomRefIDv5(seeds)
+
+	if len(cfg.Upstream.Servers) > 0 {
+		// De eerste keer meteen, zodat de tijd vanaf het begin klopt
+		srv.upstream = newUpstreamClock(cfg.Upstream, cfg.Debug)
+		srv.upstream.poll()
+		srv.clock = append(srv.clock, srv.upstream)
+		go srv.upstream.follow(srv.done)
+		log.Printf("Upstream: %s, elke %v", strings.Join(cfg.Upstream.Servers, ", "), srv.upstream.interval)
+	}
+
+	clockSpecs := cfg.Clock
+	if len(clockSpecs) == 0 {
+		clockSpecs = driftSpecs(cfg)
+	}
+	if len(clockSpecs) > 0 {
+		srv.clock = append(srv.clock, newClock(clockSpecs, time.Now(), seeds)...)
+		log.Printf("Klokmodel: %s", clockString(clockSpecs))
+	}
+	// Sprongen via de control API of Step, altijd als laatste
+	srv.clock = append(srv.clock, srv.manual)
+
+	// Het schema volgt de klok van de server, niet de systeemklok
+	leap, err := newLeapSchedule(cfg.Leap, clockTime(Config{clock: srv.clock}, srv.started))
+	if err != nil {
+		srv.Close()
+		return nil, fmt.Errorf("Kan leap second niet inlezen: %v", err)
+	}
+	if leap != nil {
+		srv.leap = leap
+		log.Printf("Gepland: %v", leap)
+	} else if cfg.Leap.File != "" {
+		log.Printf("Geen aankomende leap second in %s", cfg.Leap.File)
+	}
+
+	if cfg.ControlAddr != "" {
+		if err := srv.startControl(cfg.ControlAddr); err != nil {
+			srv.Close()
+			return nil, err
+		}
+	}
+
+	if cfg.NTS.Enabled {
+		if srv.nts, err = newNTSServer(cfg, srv.configFor); err != nil {
+			srv.Close()
+			return nil, err
+		}
+		if err := srv.nts.listenKE(keListenAddr(cfg)); err != nil {
+			srv.Close()
+			return nil, err
+		}
+	}
+
+	if cfg.KernelTimestamps {
+		if err := enableRxTimestamps(conn); err != nil {
+			log.Printf("Geen kernel timestamps: %v", err)
+		} else {
+			srv.kernelRx = true
+			log.Println("Kernel ontvangst-timestamps (SO_TIMESTAMPNS) aan")
+		}
+	}
+
+	if interleavedUsed(configs) {
+		kernelTx := false
+		if cfg.KernelTimestamps {
+			if err := enableTxTimestamps(conn); err != nil {
+				log.Printf("Geen kernel verzend-timestamps: %v", err)
+			} else {
+				kernelTx = true
+				log.Println("Kernel verzend-timestamps (SO_TIMESTAMPING) aan")
+			}
+		}
+		srv.interleaved = newInterleavedState(conn, kernelTx)
+		log.Println("Interleaved mode aan")
+	}
+
+	if cfg.Broadcast.Address != "" {
+		go srv.broadcast(cfg.Broadcast)
+	}
+	if cfg.Mode7.Monlist {
+		srv.monitor = newMonitor()
+	}
+
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	log.Printf("Fake NTP-server gestart op %v (%d workers)", addr, workers)
+
+	for range workers {
+		go srv.serve()
+	}
+	return srv, nil
+}
+
+// Start start een server in dit proces, bijvoorbeeld voor een Go-test.
+// Zonder address luistert hij op 127.0.0.1, en met port 0 op een vrije
+// poort; addr is het adres waarop hij echt luistert. Voor NTS-KE geldt
+// hetzelfde adres, en zonder ke_port ook een vrije poort (zie KEAddr).
+// Listeners, metrics_addr en exchange_log horen bij het programma en worden
+// hier genegeerd. Stop de server met Close.
+func Start(cfg Config) (*Server, *net.UDPAddr, error) {
+	if cfg.Address == "" {
+		cfg.Address = "127.0.0.1"
+	}
+	cfg.NTS.freeKEPort = cfg.NTS.KEPort == 0
+	cfg.Listeners = nil
+	if err := validateConfig(cfg); err != nil {
+		return nil, nil, err
+	}
+	srv, err := startServer(cfg.clone(), "", nil, nil, nil, newMetrics())
+	if err != nil {
+		return nil, nil, err
+	}
+	return srv, srv.Addr(), nil
+}
+
+// DefaultConfig is een werkende basis voor Start: een gewone stratum
+// 1-server (refid GPS, NTPv4) zonder afwijkingen.
+func DefaultConfig() Config {
+	return Config{
+		MinPoll:          6,
+		MaxPoll:          6,
+		MinPrecision:     -20,
+		MaxPrecision:     -20,
+		MaxRefTimeOffset: 16,
+		RefIDType:        "GPS",
+		MinStratum:       1,
+		MaxStratum:       1,
+		VersionNumber:    4,
+	}
+}
+
+// Addr geeft het adres waarop de server luistert.
+func (s *Server) Addr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Gatherer geeft de metrics van deze server, dezelfde als op /metrics van
+// de control API.
+func (s *Server) Gatherer() prometheus.Gatherer {
+	return s.metrics.registry
+}
+
+// KEAddr geeft het adres van de NTS-KE-listener, of nil zonder NTS.
+func (s *Server) KEAddr() *net.TCPAddr {
+	if s.nts == nil {
+		return nil
+	}
+	return s.nts.ln.Addr().(*net.TCPAddr)
+}
+
+// Close stopt de server: de workers, broadcast, de control API en NTS-KE.
+// Vertraagde antwoorden (impair) die nog onderweg zijn, gaan verloren.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.offsetGauge != nil {
+			s.metrics.registry.Unregister(s.offsetGauge)
+		}
+		if s.control != nil {
+			s.control.Close()
+		}
+		if s.nts != nil {
+			s.nts.close()
+		}
+		err = s.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,95 @@
+package fakentp_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+
+	"fake-ntp-server/fakentp"
+)
+
+// TestStart is het voorbeeld uit de package-documentatie: starten, vragen,
+// het gedrag wijzigen met SetConfig en weer stoppen.
+func TestStart(t *testing.T) {
+	cfg := fakentp.DefaultConfig()
+	cfg.TimeOffsetMs = 500
+	srv, addr, err := fakentp.Start(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	if addr.Port == 0 || !addr.IP.IsLoopback() {
+		t.Fatalf("Start luistert op %v, verwacht een vrije poort op 127.0.0.1", addr)
+	}
+
+	resp, err := ntp.Query(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Stratum != 1 || resp.Leap != ntp.LeapNoWarning {
+		t.Errorf("stratum %d, leap %d; verwacht 1 en 0", resp.Stratum, resp.Leap)
+	}
+	if d := resp.ClockOffset + 500*time.Millisecond; d.Abs() > 100*time.Millisecond {
+		t.Errorf("offset %v, verwacht ongeveer -500ms", resp.ClockOffset)
+	}
+
+	cfg = srv.Config()
+	cfg.TimeOffsetMs = 0
+	cfg.MinStratum, cfg.MaxStratum = 3, 3
+	cfg.LeapIndicator = 3
+	if err := srv.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = ntp.Query(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Stratum != 3 || resp.Leap != ntp.LeapNotInSync {
+		t.Errorf("na SetConfig: stratum %d, leap %d; verwacht 3 en 3", resp.Stratum, resp.Leap)
+	}
+	if resp.ClockOffset.Abs() > 100*time.Millisecond {
+		t.Errorf("na SetConfig: offset %v, verwacht ongeveer 0", resp.ClockOffset)
+	}
+
+	// Een ongeldige config wordt geweigerd en laat de oude staan
+	bad := srv.Config()
+	bad.MinStratum = 20
+	if err := srv.SetConfig(bad); err == nil {
+		t.Error("SetConfig accepteert stratum 20")
+	}
+	if got := srv.Config().MinStratum; got != 3 {
+		t.Errorf("na geweigerde SetConfig is min_stratum %d, verwacht 3", got)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Errorf("tweede Close: %v", err)
+	}
+	if _, err := ntp.QueryWithOptions(addr.String(), ntp.QueryOptions{Timeout: 200 * time.Millisecond}); err == nil {
+		t.Error("antwoord na Close")
+	}
+}
+
+// TestStartNTSError: een NTS-certificaat dat niet te laden of weg te
+// schrijven is, is een fout van Start en geen exit.
+func TestStartNTSError(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "bestaat-niet", "nts.pem")
+	for name, set := range map[string]func(*fakentp.NTSConfig){
+		"cert_file": func(c *fakentp.NTSConfig) { c.CertFile, c.KeyFile = missing, missing },
+		"cert_out":  func(c *fakentp.NTSConfig) { c.CertOut = missing },
+	} {
+		cfg := fakentp.DefaultConfig()
+		cfg.NTS.Enabled = true
+		set(&cfg.NTS)
+		srv, _, err := fakentp.Start(cfg)
+		if err == nil {
+			srv.Close()
+			t.Errorf("%s: Start geeft geen fout", name)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package fakentp
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// Een gesimuleerde hiërarchie boven de server, voor geloofwaardige root
+// delay, root dispersion, refid en reference timestamp:
+//
+//	"hierarchy": {"peer": "192.0.2.1", "hop_delay_ms": 10, "hop_dispersion_ms": 1, "phi_ppm": 15}
+//
+// Vanaf stratum 2 is de refid die van peer, de gesimuleerde sys peer: het
+// IPv4-adres, of bij IPv6 de eerste vier bytes van de MD5-hash van het adres
+// (RFC 5905). Elke hop tussen de server en stratum 1 telt hop_delay_ms op bij
+// de root delay en hop_dispersion_ms bij de root dispersion. De server werkt
+// zijn klok elke 2^min_poll seconden bij; de reference timestamp is het
+// laatste van die momenten, en daarna groeit de root dispersion met phi_ppm.
+// Stratum 0 en 16 blijven zoals zonder hierarchy. Een scenario-fase kan de
+// peer laten wisselen.
+
+type HierarchyConfig struct {
+	Peer            string  `json:"peer"`              // IPv4 of IPv6; leeg = uit
+	HopDelayMs      float64 `json:"hop_delay_ms"`      // round-trip per hop, standaard 10
+	HopDispersionMs float64 `json:"hop_dispersion_ms"` // per hop, standaard 1
+	PhiPPM          float64 `json:"phi_ppm"`           // standaard 15, zoals PHI in RFC 5905
+}
+
+// Grootste update-interval, zoals maxpoll in ntpd
+const hierarchyMaxPoll = 17
+
+func validateHierarchy(cfg HierarchyConfig) error {
+	if cfg.Peer != "" {
+		if _, err := netip.ParseAddr(cfg.Peer); err != nil {
+			return fmt.Errorf("Hierarchy: ongeldige peer %q: %v", cfg.Peer, err)
+		}
+	}
+	if cfg.HopDelayMs < 0 || cfg.HopDispersionMs < 0 || cfg.PhiPPM < 0 {
+		return fmt.Errorf("Hierarchy: hop_delay_ms, hop_dispersion_ms en phi_ppm moeten >= 0 zijn")
+	}
+	return nil
+}
+
+// refIDForAddr geeft de refid van een server op addr, zoals een server
+// met die server als sys peer hem uitzendt.
+func refIDForAddr(addr netip.Addr) uint32 {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		b := addr.As4()
+		return binary.BigEndian.Uint32(b[:])
+	}
+	b := addr.As16()
+	sum := md5.Sum(b[:])
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// rootValues zijn de velden die de hiërarchie bepaalt.
+type rootValues struct {
+	delay, disp time.Duration
+	refID       uint32
+	refTime     time.Time // zoals uitgezonden
+}
+
+// hierarchyRoot geeft de waarden voor een antwoord met deze stratum, op
+// echte tijd now; false zonder hierarchy, en bij stratum 0 en 16.
+func hierarchyRoot(cfg Config, stratum uint8, now time.Time) (rootValues, bool) {
+	h := cfg.Hierarchy
+	if h.Peer == "" || stratum == 0 || stratum >= 16 {
+		return rootValues{}, false
+	}
+	hopDelay, hopDisp, phi := 10.0, 1.0, 15.0
+	if h.HopDelayMs > 0 {
+		hopDelay = h.HopDelayMs
+	}
+	if h.HopDispersionMs > 0 {
+		hopDisp = h.HopDispersionMs
+	}
+	if h.PhiPPM > 0 {
+		phi = h.PhiPPM
+	}
+
+	// Het laatste bijwerken, gerekend vanaf het starten van de server
+	interval := time.Second << min(max(cfg.MinPoll, 0), hierarchyMaxPoll)
+	update := cfg.started.Add(now.Sub(cfg.started).Truncate(interval))
+
+	hops := float64(stratum - 1)
+	v := rootValues{
+		delay:   msDuration(hops * hopDelay),
+		disp:    msDuration(hops*hopDisp) + ppmDuration(now.Sub(update), phi),
+		refTime: servedTime(cfg, update),
+	}
+	if stratum == 1 {
+		v.refID = refIDFromType(cfg.RefIDType, stratum, nil)
+	} else {
+		// Al gevalideerd
+		v.refID = refIDForAddr(netip.MustParseAddr(h.Peer))
+	}
+	return v, true
+}
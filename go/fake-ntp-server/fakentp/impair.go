@@ -0,0 +1,187 @@
+package fakentp
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Netwerkverstoringen, zonder tc/netem: verlies (los of in bursts), dubbele
+// antwoorden en vertraging. Vertraging op de heenweg komt in T2 terecht,
+// vertraging op de terugweg komt na T3; alleen de een of de ander geeft dus
+// een asymmetrisch pad en een verschoven offset bij de client. Elk pakket
+// krijgt een eigen vertraging, zodat antwoorden elkaar kunnen inhalen.
+//
+//	"impair": {
+//	  "loss": 0.05,
+//	  "duplicate": 0.01,
+//	  "request_delay":  {"ms": 20, "jitter_ms": 5, "distribution": "normal"},
+//	  "response_delay": {"ms": 80, "jitter_ms": 40, "distribution": "pareto"}
+//	}
+
+type ImpairConfig struct {
+	Loss          float64         `json:"loss"`           // kans op verlies per antwoord (0–1)
+	BurstLoss     BurstLossConfig `json:"burst_loss"`     // verlies in bursts (Gilbert-Elliott)
+	Duplicate     float64         `json:"duplicate"`      // kans dat een antwoord twee keer gaat
+	RequestDelay  DelayConfig     `json:"request_delay"`  // heenweg: het verzoek komt later aan
+	ResponseDelay DelayConfig     `json:"response_delay"` // terugweg: na de transmit timestamp
+}
+
+// DelayConfig is een vertraging van ms, met spreiding jitter_ms:
+//
+//	uniform      ms ± jitter_ms (standaard)
+//	normal       ms + normaal verdeeld, standaardafwijking jitter_ms
+//	exponential  ms + exponentieel verdeeld, gemiddeld jitter_ms
+//	pareto       ms + pareto-verdeeld (lange staart), gemiddeld jitter_ms
+//
+// Negatieve uitkomsten worden 0.
+type DelayConfig struct {
+	Ms           float64 `json:"ms"`
+	JitterMs     float64 `json:"jitter_ms"`
+	Distribution string  `json:"distribution"`
+}
+
+// BurstLossConfig is het Gilbert-Elliott-model: een goede en een slechte
+// toestand, met per antwoord kans p om van goed naar slecht te gaan en kans
+// r om terug te gaan. Uit zolang p 0 is.
+type BurstLossConfig struct {
+	P        float64 `json:"p"`
+	R        float64 `json:"r"`
+	LossGood float64 `json:"loss_good"` // verlies in de goede toestand, standaard 0
+	LossBad  float64 `json:"loss_bad"`  // verlies in de slechte toestand; 0 = 1 (alles)
+}
+
+func validateImpair(cfg ImpairConfig) error {
+	probs := []struct {
+		name string
+		v    float64
+	}{
+		{"loss", cfg.Loss},
+		{"duplicate", cfg.Duplicate},
+		{"burst_loss.p", cfg.BurstLoss.P},
+		{"burst_loss.r", cfg.BurstLoss.R},
+		{"burst_loss.loss_good", cfg.BurstLoss.LossGood},
+		{"burst_loss.loss_bad", cfg.BurstLoss.LossBad},
+	}
+	for _, p := range probs {
+		if p.v < 0 || p.v > 1 {
+			return fmt.Errorf("Impair: %s moet tussen 0 en 1 liggen, niet %v", p.name, p.v)
+		}
+	}
+	delays := []struct {
+		name string
+		d    DelayConfig
+	}{
+		{"request_delay", cfg.RequestDelay},
+		{"response_delay", cfg.ResponseDelay},
+	}
+	for _, d := range delays {
+		if d.d.Ms < 0 || d.d.JitterMs < 0 {
+			return fmt.Errorf("Impair: %s: ms en jitter_ms moeten >= 0 zijn", d.name)
+		}
+		switch d.d.Distribution {
+		case "", "uniform", "normal", "exponential", "pareto":
+		default:
+			return fmt.Errorf("Impair: %s: onbekende distribution %q (uniform, normal, exponential, pareto)", d.name, d.d.Distribution)
+		}
+	}
+	return nil
+}
+
+// paretoShape bepaalt hoe lang de staart is; bij 1,5 is het gemiddelde nog
+// eindig maar de variantie niet.
+const paretoShape = 1.5
+
+// draw trekt een vertraging.
+func (d DelayConfig) draw(r *lockedRand) time.Duration {
+	if d.Ms == 0 && d.JitterMs == 0 {
+		return 0
+	}
+	ms := d.Ms
+	switch d.Distribution {
+	case "normal":
+		ms += r.NormFloat64() * d.JitterMs
+	case "exponential":
+		ms += r.ExpFloat64() * d.JitterMs
+	case "pareto":
+		// Gemiddelde van u^(-1/a) - 1 is 1/(a-1)
+		x := math.Pow(1-r.Float64(), -1/paretoShape) - 1
+		ms += x * (paretoShape - 1) * d.JitterMs
+	default:
+		ms += (r.Float64()*2 - 1) * d.JitterMs
+	}
+	return max(0, msDuration(ms))
+}
+
+// burstLoss is de toestand van het Gilbert-Elliott-model, voor de hele
+// server (zoals netem per interface).
+type burstLoss struct {
+	mu  sync.Mutex
+	bad bool
+}
+
+// lost meldt of een antwoord verloren gaat.
+func (b *burstLoss) lost(cfg ImpairConfig, r *lockedRand) bool {
+	if cfg.Loss > 0 && r.Float64() < cfg.Loss {
+		return true
+	}
+	ge := cfg.BurstLoss
+	if ge.P == 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	bad := b.bad
+	if bad {
+		b.bad = r.Float64() >= ge.R
+	} else {
+		b.bad = r.Float64() < ge.P
+	}
+	b.mu.Unlock()
+
+	loss := ge.LossGood
+	if bad {
+		loss = ge.LossBad
+		if loss == 0 {
+			loss = 1
+		}
+	}
+	return r.Float64() < loss
+}
+
+// send verstuurt een antwoord met de verstoringen uit cfg.Impair: verlies,
+// duplicaten en vertraging op de terugweg. lost meldt of het antwoord
+// (opzettelijk) verloren ging. Een vertraagd antwoord gaat later vanuit een
+// timer; fouten daarvan komen niet terug.
+func (s *Server) send(resp []byte, clientAddr *net.UDPAddr, cfg Config) (lost bool, err error) {
+	imp := cfg.Impair
+	if s.burst.lost(imp, cfg.rand) {
+		if cfg.Debug {
+			fmt.Printf("  - Antwoord naar %s verloren (impair)\n", clientAddr.IP.String())
+		}
+		return true, nil
+	}
+
+	copies := 1
+	if imp.Duplicate > 0 && cfg.rand.Float64() < imp.Duplicate {
+		copies = 2
+		if cfg.Debug {
+			fmt.Printf("  - Antwoord naar %s gaat twee keer (impair)\n", clientAddr.IP.String())
+		}
+	}
+	for range copies {
+		d := imp.ResponseDelay.draw(cfg.rand)
+		if d == 0 {
+			if _, e := s.conn.WriteToUDP(resp, clientAddr); e != nil {
+				err = e
+			}
+			continue
+		}
+		time.AfterFunc(d, func() {
+			s.conn.WriteToUDP(resp, clientAddr)
+		})
+	}
+	return false, err
+}
@@ -0,0 +1,137 @@
+package fakentp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Interleaved mode (RFC 9769, zoals chrony het doet): de server stuurt in
+// zijn antwoord de precieze transmit timestamp van het *vorige* antwoord aan
+// dezelfde client mee. Die is pas na het versturen bekend, liefst van de
+// kernel (SO_TIMESTAMPING).
+//
+// Een client vraagt om interleaved mode door als origin timestamp de
+// receive timestamp van ons vorige antwoord terug te sturen (in basic mode
+// is dat de transmit timestamp). Het antwoord bevat dan:
+//
+//	origin   = receive timestamp uit het verzoek
+//	receive  = T2 van dit verzoek
+//	transmit = precieze T3 van het vorige antwoord
+//
+// Kennen we de client niet (meer), dan volgt gewoon een basic antwoord.
+
+const maxInterleavedClients = 65536
+
+type interleavedClient struct {
+	rx     uint64        // receive timestamp (NTP-formaat) in ons vorige antwoord
+	tx     time.Time     // T3 van het vorige antwoord zoals verstuurd (userspace)
+	shift  time.Duration // verschil tussen uitgezonden tijd en echte tijd bij T3
+	kernel time.Time     // verzendtijd van de kernel, zodra bekend
+}
+
+type interleavedState struct {
+	conn     *net.UDPConn
+	kernelTx bool
+
+	mu      sync.Mutex
+	clients map[netip.Addr]*interleavedClient
+	// Nog niet gematchte verzonden antwoorden, op receive+transmit timestamp
+	pending map[[16]byte]*interleavedClient
+}
+
+func newInterleavedState(conn *net.UDPConn, kernelTx bool) *interleavedState {
+	return &interleavedState{
+		conn:     conn,
+		kernelTx: kernelTx,
+		clients:  make(map[netip.Addr]*interleavedClient),
+		pending:  make(map[[16]byte]*interleavedClient),
+	}
+}
+
+// previousTx geeft de transmit timestamp voor een interleaved antwoord, of
+// false als het verzoek geen interleaved verzoek van een bekende client is.
+// org is de origin timestamp uit het verzoek (v4) of het server cookie (v5).
+func (st *interleavedState) previousTx(clientIP netip.Addr, org uint64) (time.Time, bool) {
+	if org == 0 {
+		return time.Time{}, false
+	}
+	if st.kernelTx {
+		st.collect()
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	c := st.clients[clientIP]
+	if c == nil || c.rx != org {
+		return time.Time{}, false
+	}
+	if !c.kernel.IsZero() {
+		return c.kernel.Add(c.shift), true
+	}
+	return c.tx, true
+}
+
+// sent registreert een verstuurd antwoord. tx is de echte tijd waarop T3 is
+// ingevuld, served de tijd die daadwerkelijk in het pakket staat (of zou
+// staan, in basic mode).
+func (st *interleavedState) sent(clientIP netip.Addr, resp []byte, tx, served time.Time) {
+	c := &interleavedClient{
+		rx:    binary.BigEndian.Uint64(resp[32:40]),
+		tx:    served,
+		shift: served.Sub(tx),
+	}
+
+	st.mu.Lock()
+	if len(st.clients) >= maxInterleavedClients || len(st.pending) >= maxInterleavedClients {
+		clear(st.clients)
+		clear(st.pending)
+	}
+	st.clients[clientIP] = c
+	if st.kernelTx {
+		var key [16]byte
+		copy(key[:], resp[32:48])
+		st.pending[key] = c
+	}
+	st.mu.Unlock()
+
+	if st.kernelTx {
+		st.collect()
+	}
+}
+
+// collect leest de kernel-verzendtijden uit de error queue en koppelt ze aan
+// de verstuurde antwoorden. De teruggestuurde data bevat het hele pakket met
+// headers; we zoeken de receive+transmit timestamp erin op.
+func (st *interleavedState) collect() {
+	readTxTimestamps(st.conn, func(data []byte, t time.Time) {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		for key, c := range st.pending {
+			if bytes.Contains(data, key[:]) {
+				c.kernel = t
+				delete(st.pending, key)
+				return
+			}
+		}
+	})
+}
+
+// interleavedUsed zegt of interleaved mode ergens aan kan staan: in de
+// basisconfig, in een scenario-fase of in een client-profiel.
+func interleavedUsed(set *configSet) bool {
+	for _, r := range append([]resolvedConfig{set.base}, set.phases...) {
+		if r.cfg.Interleaved {
+			return true
+		}
+		for _, c := range r.clients {
+			if c.Interleaved {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package fakentp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"fake-ntp-server/fakentp"
+)
+
+// TestInterleaved: twee uitwisselingen zoals chrony ze doet. Het tweede
+// verzoek stuurt de receive timestamp van het eerste antwoord terug als
+// origin en krijgt dan de T3 van het eerste antwoord; een verzoek met een
+// andere origin krijgt gewoon een basic antwoord.
+func TestInterleaved(t *testing.T) {
+	cfg := fakentp.DefaultConfig()
+	cfg.Interleaved = true
+	srv, addr, err := fakentp.Start(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	request := func(org, rx, tx uint64) []byte {
+		req := make([]byte, fakentp.NtpPacketSize)
+		req[0] = 4<<3 | 3
+		binary.BigEndian.PutUint64(req[24:], org)
+		binary.BigEndian.PutUint64(req[32:], rx)
+		binary.BigEndian.PutUint64(req[40:], tx)
+		resp, err := roundTrip(addr, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Eerste uitwisseling: basic, origin = transmit van de client
+	first := request(0, 0, 0x1111111111111111)
+	if org := binary.BigEndian.Uint64(first[24:]); org != 0x1111111111111111 {
+		t.Fatalf("eerste antwoord: origin %#x, verwacht de transmit van de client", org)
+	}
+
+	// Tweede: origin = receive van het eerste antwoord, dus interleaved
+	second := request(binary.BigEndian.Uint64(first[32:]), 0x2222222222222222, 0x3333333333333333)
+	if org := binary.BigEndian.Uint64(second[24:]); org != 0x2222222222222222 {
+		t.Errorf("tweede antwoord: origin %#x, verwacht de receive van de client", org)
+	}
+	if !bytes.Equal(second[40:48], first[40:48]) {
+		t.Errorf("tweede antwoord: transmit %x, verwacht de vorige transmit %x", second[40:48], first[40:48])
+	}
+	if bytes.Equal(second[32:40], first[32:40]) {
+		t.Error("tweede antwoord: receive is die van het eerste antwoord")
+	}
+
+	// Derde: origin past niet (de receive van het eerste antwoord in plaats
+	// van het tweede), dus terug naar basic
+	third := request(binary.BigEndian.Uint64(first[32:]), 0x4444444444444444, 0x5555555555555555)
+	if org := binary.BigEndian.Uint64(third[24:]); org != 0x5555555555555555 {
+		t.Errorf("derde antwoord: origin %#x, verwacht de transmit van de client", org)
+	}
+	if rx, tx := binary.BigEndian.Uint64(third[32:]), binary.BigEndian.Uint64(third[40:]); tx < rx {
+		t.Errorf("derde antwoord: transmit %#x voor receive %#x, niet basic", tx, rx)
+	}
+}
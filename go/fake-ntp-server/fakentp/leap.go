@@ -0,0 +1,213 @@
+package fakentp
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Leap seconds: een aangekondigde schrikkelseconde uit een IETF
+// leap-seconds.list, of een zelf gekozen moment uit de config. Gedurende de
+// laatste dag ervoor gaat de leap indicator op 1 (invoegen) of 2
+// (weglaten); op het moment zelf maakt de klok van de server de sprong:
+//
+//	insert: 23:59:59 komt twee keer (NTP kent geen 23:59:60)
+//	delete: 23:59:59 wordt overgeslagen
+//
+// Met smear verdwijnt de sprong in een geleidelijke verschuiving (lineair
+// of cosinus, zoals Google) en blijft de leap indicator 0.
+
+type LeapConfig struct {
+	File    string  `json:"file"`     // leap-seconds.list; de eerstvolgende leap second telt
+	At      string  `json:"at"`       // eerste moment na de leap second (RFC 3339), bv. "2027-01-01T00:00:00Z"
+	InSec   float64 `json:"in_sec"`   // of: zoveel seconden na het starten
+	Type    string  `json:"type"`     // "insert" (standaard) of "delete", bij at/in_sec
+	WarnSec float64 `json:"warn_sec"` // leap indicator zoveel seconden vooraf, standaard 86400
+
+	Smear          string  `json:"smear"`            // "" (sprong), "linear" of "cosine"
+	SmearWindowSec float64 `json:"smear_window_sec"` // standaard 86400, gecentreerd op de sprong
+}
+
+type leapSchedule struct {
+	at     time.Time // eerste moment na de leap second (00:00:00 UTC)
+	insert bool
+	warn   time.Duration
+	smear  string
+	window time.Duration
+}
+
+func validateLeap(cfg LeapConfig) error {
+	set := 0
+	for _, b := range []bool{cfg.File != "", cfg.At != "", cfg.InSec != 0} {
+		if b {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("Leap second: kies één van file, at en in_sec")
+	}
+	if cfg.At != "" {
+		if _, err := time.Parse(time.RFC3339, cfg.At); err != nil {
+			return fmt.Errorf("Leap second: ongeldig tijdstip %q: %v", cfg.At, err)
+		}
+	}
+	if cfg.InSec < 0 || cfg.WarnSec < 0 || cfg.SmearWindowSec < 0 {
+		return fmt.Errorf("Leap second: in_sec, warn_sec en smear_window_sec moeten >= 0 zijn")
+	}
+	switch cfg.Type {
+	case "", "insert", "delete":
+	default:
+		return fmt.Errorf("Leap second: onbekend type %q (insert of delete)", cfg.Type)
+	}
+	switch cfg.Smear {
+	case "", "linear", "cosine":
+	default:
+		return fmt.Errorf("Leap second: onbekende smear %q (linear of cosine)", cfg.Smear)
+	}
+	return nil
+}
+
+// newLeapSchedule bepaalt het moment van de leap second; nil als er geen
+// is ingesteld of de lijst geen toekomstige leap second bevat.
+func newLeapSchedule(cfg LeapConfig, start time.Time) (*leapSchedule, error) {
+	ls := &leapSchedule{
+		insert: cfg.Type != "delete",
+		warn:   24 * time.Hour,
+		smear:  cfg.Smear,
+		window: 24 * time.Hour,
+	}
+	if cfg.WarnSec > 0 {
+		ls.warn = time.Duration(cfg.WarnSec * float64(time.Second))
+	}
+	if cfg.SmearWindowSec > 0 {
+		ls.window = time.Duration(cfg.SmearWindowSec * float64(time.Second))
+	}
+
+	switch {
+	case cfg.File != "":
+		at, insert, err := nextLeapFromFile(cfg.File, start)
+		if err != nil || at.IsZero() {
+			return nil, err
+		}
+		ls.at, ls.insert = at, insert
+	case cfg.At != "":
+		ls.at, _ = time.Parse(time.RFC3339, cfg.At)
+	case cfg.InSec > 0:
+		ls.at = start.Add(time.Duration(cfg.InSec * float64(time.Second)))
+	default:
+		return nil, nil
+	}
+	return ls, nil
+}
+
+// nextLeapFromFile leest een leap-seconds.list (IERS/IETF-formaat): regels
+// "<NTP-seconden> <TAI-UTC>", commentaar met '#', vervaldatum in "#@". De
+// eerste regel na after geeft de leap second; of die invoegt of weglaat
+// volgt uit het verschil met de vorige TAI-UTC.
+func nextLeapFromFile(path string, after time.Time) (time.Time, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer f.Close()
+
+	var prev int64
+	first := true
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "#@") {
+			if exp, err := strconv.ParseInt(strings.TrimSpace(line[2:]), 10, 64); err == nil {
+				if t := ntpSecondsToTime(exp); t.Before(after) {
+					log.Printf("Let op: %s is verlopen sinds %s", path, t.Format(timeFormat))
+				}
+			}
+			continue
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		secs, err1 := strconv.ParseInt(fields[0], 10, 64)
+		dtai, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			return time.Time{}, false, fmt.Errorf("%s: ongeldige regel %q", path, line)
+		}
+		if t := ntpSecondsToTime(secs); !first && t.After(after) {
+			return t, dtai > prev, nil
+		}
+		prev, first = dtai, false
+	}
+	return time.Time{}, false, sc.Err()
+}
+
+func ntpSecondsToTime(secs int64) time.Time {
+	return time.Unix(secs-NtpEpochOffset, 0).UTC()
+}
+
+// indicator geeft de leap indicator op tijdstip t van de serverklok (0
+// buiten de waarschuwingsperiode of bij smearing).
+func (ls *leapSchedule) indicator(t time.Time) int {
+	if ls.smear != "" || t.Before(ls.at.Add(-ls.warn)) || !t.Before(ls.step()) {
+		return 0
+	}
+	if ls.insert {
+		return 1
+	}
+	return 2
+}
+
+// shift is de verschuiving van de serverklok door de leap second op
+// tijd t van de serverklok (zie clockTime): na een insert loopt de server
+// een seconde achter, na een delete een seconde voor.
+func (ls *leapSchedule) shift(t time.Time) time.Duration {
+	var frac float64 // deel van de seconde dat al verwerkt is
+	switch ls.smear {
+	case "":
+		if !t.Before(ls.step()) {
+			frac = 1
+		}
+	default:
+		x := float64(t.Sub(ls.at.Add(-ls.window/2))) / float64(ls.window)
+		x = math.Max(0, math.Min(1, x))
+		if ls.smear == "cosine" {
+			x = (1 - math.Cos(math.Pi*x)) / 2
+		}
+		frac = x
+	}
+
+	d := time.Duration(frac * float64(time.Second))
+	if ls.insert {
+		return -d
+	}
+	return d
+}
+
+// step is het moment van de sprong. Bij delete is dat een seconde eerder:
+// na 23:59:58 volgt meteen 00:00:00.
+func (ls *leapSchedule) step() time.Time {
+	if ls.insert {
+		return ls.at
+	}
+	return ls.at.Add(-time.Second)
+}
+
+func (ls *leapSchedule) String() string {
+	kind := "insert"
+	if !ls.insert {
+		kind = "delete"
+	}
+	s := fmt.Sprintf("leap second (%s) op %s", kind, ls.at.UTC().Format(timeFormat))
+	if ls.smear != "" {
+		return s + fmt.Sprintf(", %s smear over %v", ls.smear, ls.window)
+	}
+	return s + fmt.Sprintf(", leap indicator vanaf %s", ls.at.Add(-ls.warn).UTC().Format(timeFormat))
+}
@@ -0,0 +1,46 @@
+package fakentp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+
+	"fake-ntp-server/fakentp"
+)
+
+// TestLeapFollowsServerClock: het leap-second-schema loopt op de klok van
+// de server. Met een epoch-klok vlak voor de leap second staat de leap
+// indicator aan, en na de sprong loopt de klok een seconde achter.
+func TestLeapFollowsServerClock(t *testing.T) {
+	cfg := fakentp.DefaultConfig()
+	cfg.Clock = []fakentp.ClockSpec{{Model: "epoch", Start: "2036-06-30T23:59:58Z"}}
+	cfg.Leap = fakentp.LeapConfig{At: "2036-07-01T00:00:00Z", WarnSec: 60}
+	srv, addr, err := fakentp.Start(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	before, err := ntp.Query(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Leap != ntp.LeapAddSecond {
+		t.Errorf("leap indicator %d vlak voor de leap second, verwacht 1", before.Leap)
+	}
+
+	// Met Step over de sprong heen, in plaats van te wachten
+	srv.Step(5 * time.Second)
+	after, err := ntp.Query(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Leap != ntp.LeapNoWarning {
+		t.Errorf("leap indicator %d na de leap second, verwacht 0", after.Leap)
+	}
+	want := time.Date(2036, time.July, 1, 0, 0, 2, 0, time.UTC) // 23:59:58 + 5s - 1s
+	if d := after.Time.Sub(want); d < 0 || d > 2*time.Second {
+		t.Errorf("tijd na de leap second %v, verwacht ongeveer %v", after.Time, want)
+	}
+}
@@ -0,0 +1,108 @@
+package fakentp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// Listener is een extra NTP-server in hetzelfde proces, met een eigen adres,
+// poort en gedrag. Zo speelt één fake-ntpd een hele pool na:
+//
+//	"listeners": [
+//	  { "name": "goed-1", "config": { "port": 11123 } },
+//	  { "name": "goed-2", "config": { "address": "::1", "port": 11123 } },
+//	  { "name": "falseticker", "config": { "port": 11124, "time_offset_ms": -800 } },
+//	  { "name": "dood", "config": { "port": 11125, "drop": true } }
+//	]
+//
+// De config van een listener wordt over de basisconfig gelegd, net als een
+// client-profiel. Elke listener heeft zijn eigen toestand: klok, rate
+// limiting, interleaved mode, NTS en control API.
+type Listener struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config"`
+}
+
+// listenerConfigs geeft de config per listener; zonder listeners is dat
+// alleen de basisconfig. Listeners erven control_addr en nts.ke_port van
+// de basisconfig; twee listeners op hetzelfde adres geven een fout, in
+// plaats van een bind-fout bij het starten.
+func listenerConfigs(cfg Config) ([]Config, error) {
+	if len(cfg.Listeners) == 0 {
+		return []Config{cfg}, nil
+	}
+	base := cfg
+	base.Listeners = nil
+	var configs []Config
+	for i, l := range cfg.Listeners {
+		lcfg, err := applyOverride(base, l.Config)
+		if err == nil {
+			lcfg.Listeners = nil // geen listeners binnen listeners
+			err = validateConfig(lcfg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Listener %d (%q): %v", i, l.Name, err)
+		}
+		for j, other := range configs {
+			if err := sameAddrs(lcfg, other); err != nil {
+				return nil, fmt.Errorf("Listener %d (%q) en %d (%q): %v; geef elke listener een eigen adres", i, l.Name, j, cfg.Listeners[j].Name, err)
+			}
+		}
+		configs = append(configs, lcfg)
+	}
+	return configs, nil
+}
+
+// sameAddrs geeft een fout als a en b dezelfde control API of NTS-KE-poort
+// zouden openen.
+func sameAddrs(a, b Config) error {
+	// net.Listen("tcp") op 0.0.0.0 of :: luistert op IPv4 én IPv6
+	if a.ControlAddr != "" && b.ControlAddr != "" && addrsOverlap(a.ControlAddr, b.ControlAddr, true) {
+		return fmt.Errorf("zelfde control_addr %s", a.ControlAddr)
+	}
+	// NTS-KE is net als de NTP-socket alleen IPv4 of alleen IPv6
+	if a.NTS.Enabled && b.NTS.Enabled && addrsOverlap(keListenAddr(a), keListenAddr(b), false) {
+		return fmt.Errorf("zelfde NTS-KE-adres %s", keListenAddr(a))
+	}
+	return nil
+}
+
+// addrsOverlap zegt of twee host:poort-adressen om dezelfde poort
+// concurreren: zelfde poort, en zelfde host of een host die de ander
+// omvat (leeg, 0.0.0.0, ::). Poort 0 (een vrije poort) botst nooit.
+func addrsOverlap(a, b string, dualStack bool) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	if portA != portB || portA == "0" {
+		return false
+	}
+	if hostA == hostB || hostA == "" || hostB == "" {
+		return true
+	}
+	covers := func(wildcard, host string) bool {
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			return false
+		}
+		switch wildcard {
+		case "0.0.0.0":
+			return dualStack || ip.Is4()
+		case "::":
+			return dualStack || ip.Is6()
+		}
+		return false
+	}
+	return covers(hostA, hostB) || covers(hostB, hostA)
+}
+
+func listenerName(cfg Config, i int) string {
+	if i < len(cfg.Listeners) && cfg.Listeners[i].Name != "" {
+		return cfg.Listeners[i].Name
+	}
+	return fmt.Sprintf("listener %d", i)
+}
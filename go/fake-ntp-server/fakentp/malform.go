@@ -0,0 +1,99 @@
+package fakentp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+)
+
+// Kapotte antwoorden, om te testen of clients ze netjes weigeren. In
+// "malform" staan de gevallen bij naam; per antwoord wordt er één gekozen:
+//
+//	truncated          korter dan 48 bytes
+//	wrong_mode         mode is niet 4 (server)
+//	bad_origin         origin timestamp klopt niet met het verzoek (spoofing)
+//	zero_transmit      transmit timestamp 0
+//	rx_after_tx        receive timestamp een seconde na transmit
+//	version_0          version number 0
+//	version_7          version number 7
+//	oversized_ef       extension field dat langer zegt te zijn dan het pakket
+//	wrong_source_port  antwoord vanaf een andere poort dan 123
+//	all                een willekeurig geval uit de lijst hierboven
+var malformCases = []string{
+	"truncated",
+	"wrong_mode",
+	"bad_origin",
+	"zero_transmit",
+	"rx_after_tx",
+	"version_0",
+	"version_7",
+	"oversized_ef",
+	"wrong_source_port",
+}
+
+func validateMalform(cases []string) error {
+	for _, c := range cases {
+		if c != "all" && !slices.Contains(malformCases, c) {
+			return fmt.Errorf("Onbekend malform-geval %q (%v of all)", c, malformCases)
+		}
+	}
+	return nil
+}
+
+// sendMalformed verknoeit een verder kant-en-klaar antwoord en verstuurt
+// het.
+func (s *Server) sendMalformed(ex *exchange, resp []byte, clientAddr *net.UDPAddr, cfg Config) {
+	c := cfg.Malform[cfg.rand.Intn(len(cfg.Malform))]
+	if c == "all" {
+		c = malformCases[cfg.rand.Intn(len(malformCases))]
+	}
+	ex.action, ex.reason = "malformed", c
+	defer func() {
+		ex.resp = resp
+		s.record(ex)
+	}()
+	if cfg.Debug {
+		fmt.Printf("  - Kapot antwoord naar %s: %s\n", clientAddr.IP.String(), c)
+	}
+
+	switch c {
+	case "truncated":
+		resp = resp[:cfg.rand.Intn(NtpPacketSize)]
+	case "wrong_mode":
+		mode := uint8(cfg.rand.Intn(7)) // alles behalve 4
+		if mode >= 4 {
+			mode++
+		}
+		resp[0] = resp[0]&0xf8 | mode
+	case "bad_origin":
+		binary.BigEndian.PutUint64(resp[24:], binary.BigEndian.Uint64(resp[24:])^(cfg.rand.Uint64()|1))
+	case "zero_transmit":
+		binary.BigEndian.PutUint64(resp[40:], 0)
+	case "rx_after_tx":
+		tx := binary.BigEndian.Uint64(resp[40:])
+		binary.BigEndian.PutUint64(resp[32:], tx+1<<32)
+	case "version_0":
+		resp[0] &^= 0x38
+	case "version_7":
+		resp[0] |= 0x38
+	case "oversized_ef":
+		// Type 0x0000 (gereserveerd), lengte 0xfffc, maar maar 28 bytes
+		ef := make([]byte, 32)
+		binary.BigEndian.PutUint16(ef[2:], 0xfffc)
+		resp = append(resp, ef...)
+	case "wrong_source_port":
+		conn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			if cfg.Debug {
+				fmt.Printf("  - Geen andere poort: %v\n", err)
+			}
+			return
+		}
+		// Zonder impair: een losse socket per antwoord
+		defer conn.Close()
+		conn.WriteToUDP(resp, clientAddr)
+		return
+	}
+	ex.lost, _ = s.send(resp, clientAddr, cfg)
+}
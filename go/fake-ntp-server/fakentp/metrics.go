@@ -0,0 +1,131 @@
+package fakentp
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus-metrics, op /metrics van metrics_addr en van de control API.
+// Elke listener heeft een eigen label; zonder listeners is dat leeg.
+//
+// De metrics staan in een eigen registry, niet in die van Prometheus zelf:
+// het programma deelt er één tussen alle listeners, en elke server uit
+// Start krijgt een eigen, zodat tests elkaars tellers niet zien.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests  *prometheus.CounterVec
+	responses *prometheus.CounterVec
+	kod       *prometheus.CounterVec
+	drops     *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "fake_ntpd_requests_total",
+				Help: "Ontvangen verzoeken, per NTP-versie en mode",
+			},
+			[]string{"listener", "version", "mode"},
+		),
+		responses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "fake_ntpd_responses_total",
+				Help: "Verstuurde antwoorden, per soort (response, kod, nts_nak, malformed, broadcast)",
+			},
+			[]string{"listener", "action"},
+		),
+		kod: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "fake_ntpd_kod_total",
+				Help: "Verstuurde Kiss-o'-Death-antwoorden, per kiss code",
+			},
+			[]string{"listener", "code"},
+		),
+		drops: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "fake_ntpd_drops_total",
+				Help: "Verzoeken zonder antwoord, per reden (paused, drop, mode, rate_limit, nts, loss)",
+			},
+			[]string{"listener", "reason"},
+		),
+	}
+	m.registry.MustRegister(m.requests, m.responses, m.kod, m.drops)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// record telt een afgehandeld verzoek en schrijft het in de exchange log.
+func (s *Server) record(ex *exchange) {
+	m := s.metrics
+	m.requests.WithLabelValues(s.name, strconv.Itoa(int(ex.version)), strconv.Itoa(int(ex.mode))).Inc()
+	switch {
+	case ex.action == "drop":
+		m.drops.WithLabelValues(s.name, ex.reason).Inc()
+	case ex.lost:
+		m.drops.WithLabelValues(s.name, "loss").Inc()
+	default:
+		m.responses.WithLabelValues(s.name, ex.action).Inc()
+		if ex.action == "kod" || ex.action == "nts_nak" {
+			m.kod.WithLabelValues(s.name, ex.reason).Inc()
+		}
+	}
+	if s.xlog != nil {
+		s.xlog.write(ex.entry(s.name))
+	}
+}
+
+// recordDrop legt vast dat een verzoek geen antwoord krijgt.
+func (s *Server) recordDrop(ex *exchange, reason string) {
+	ex.action, ex.reason = "drop", reason
+	s.record(ex)
+}
+
+// registerOffsetMetric maakt de gauge met de huidige afwijking van de
+// server.
+func (s *Server) registerOffsetMetric() {
+	g := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name:        "fake_ntpd_offset_seconds",
+			Help:        "Tijd van de server min de systeemklok (klokmodellen, offset, replay, leap second; zonder jitter)",
+			ConstLabels: prometheus.Labels{"listener": s.name},
+		},
+		s.currentOffset,
+	)
+	if err := s.metrics.registry.Register(g); err != nil {
+		log.Printf("Geen offset-metric voor %q: %v", s.name, err)
+		return
+	}
+	s.offsetGauge = g
+}
+
+func (s *Server) currentOffset() float64 {
+	now := time.Now()
+	cfg := s.configFor(now, netip.Addr{})
+	return servedTime(cfg, now).Sub(now).Seconds()
+}
+
+func serveMetrics(addr string, m *metrics) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.handler())
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Kan metrics niet starten: %v", err)
+	}
+	go http.Serve(ln, mux)
+	log.Printf("Metrics op http://%s/metrics", ln.Addr())
+	return nil
+}
@@ -0,0 +1,327 @@
+package fakentp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mode 6 (control, RFC 9327): genoeg om `ntpq -c rv`, `ntpq -c as` en
+// `ntpq -p` te beantwoorden. Met de variabelen uit de config kan elke
+// ntpd nagespeeld worden:
+//
+//	"mode6": {
+//	  "enabled": true,
+//	  "system": {"version": "\"ntpd 4.2.6p5@1.2349-o\""},
+//	  "peers": [
+//	    {"select": "sys_peer", "vars": {"srcadr": "192.0.2.1", "refid": "GPS", "offset": "0.012"}},
+//	    {"vars": {"srcadr": "192.0.2.2", "stratum": "2", "refid": "192.0.2.9"}}
+//	  ]
+//	}
+//
+// Waarden gaan letterlijk mee; strings dus zelf tussen aanhalingstekens.
+// Schrijven (writevar, config) mag niet.
+
+type Mode6Config struct {
+	Enabled bool              `json:"enabled"`
+	System  map[string]string `json:"system"` // vult de systeemvariabelen aan of vervangt ze
+	Peers   []Mode6Peer       `json:"peers"`  // association ID 1, 2, ...
+}
+
+type Mode6Peer struct {
+	// reject, falsetick, excess, outlier, candidate, backup, sys_peer of
+	// pps_peer; standaard sys_peer voor de eerste en candidate voor de rest
+	Select string            `json:"select"`
+	Vars   map[string]string `json:"vars"`
+}
+
+// Selectiestatus in het peer status word, in de volgorde van RFC 9327
+var peerSelect = []string{"reject", "falsetick", "excess", "outlier", "candidate", "backup", "sys_peer", "pps_peer"}
+
+const (
+	ctlHeaderSize = 12
+	ctlMaxData    = 468 // per fragment, zoals ntpd
+
+	ctlOpReadStat = 1
+	ctlOpReadVar  = 2
+
+	ctlErrPermission = 1
+	ctlErrBadOp      = 3
+	ctlErrBadAssoc   = 4
+
+	// Peer status: configured en reachable
+	ctlPeerConfigured = 0x80
+	ctlPeerReachable  = 0x10
+)
+
+func validateMode6(cfg Mode6Config) error {
+	for i, p := range cfg.Peers {
+		if p.Select != "" && !slices.Contains(peerSelect, p.Select) {
+			return fmt.Errorf("Mode 6: peer %d: onbekende select %q (%s)", i, p.Select, strings.Join(peerSelect, ", "))
+		}
+	}
+	return nil
+}
+
+// ctlVar is één variabele; de volgorde blijft zoals bij ntpd.
+type ctlVar struct {
+	name, value string
+}
+
+// withVars vervangt of vult de standaardvariabelen aan; nieuwe komen
+// alfabetisch achteraan.
+func withVars(vars []ctlVar, extra map[string]string) []ctlVar {
+	for i, v := range vars {
+		if val, ok := extra[v.name]; ok {
+			vars[i].value = val
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(extra)) {
+		if !slices.ContainsFunc(vars, func(v ctlVar) bool { return v.name == name }) {
+			vars = append(vars, ctlVar{name, extra[name]})
+		}
+	}
+	return vars
+}
+
+// varsText maakt er "naam=waarde, naam=waarde" van, met een regelovergang
+// na ongeveer 72 tekens. Met want alleen de gevraagde variabelen.
+func varsText(vars []ctlVar, want []string) []byte {
+	var b []byte
+	line := 0
+	for _, v := range vars {
+		if len(want) > 0 && !slices.Contains(want, v.name) {
+			continue
+		}
+		item := v.name + "=" + v.value
+		if len(b) > 0 {
+			if line+len(item) > 70 {
+				b = append(b, ",\r\n"...)
+				line = 0
+			} else {
+				b = append(b, ", "...)
+				line += 2
+			}
+		}
+		b = append(b, item...)
+		line += len(item)
+	}
+	return append(b, "\r\n"...)
+}
+
+// ntpHex is een NTP-timestamp zoals ntpd hem in variabelen zet.
+func ntpHex(t time.Time) string {
+	sec, frac := ntpTimestampParts(t)
+	return fmt.Sprintf("0x%08x.%08x", sec, frac)
+}
+
+// systemVars zijn de systeemvariabelen voor `ntpq -c rv`. Stratum,
+// precision, root delay/dispersion, refid en reftime komen uit een mode
+// 4-antwoord van createFakeNTPResponse, zodat ranges, hierarchy en replay
+// hier hetzelfde uitpakken als voor een gewone client.
+func (s *Server) systemVars(cfg Config, now time.Time) []ctlVar {
+	served := servedTime(cfg, now)
+	v4 := createFakeNTPResponse(make([]byte, NtpPacketSize), cfg, now)
+	stratum := v4[1]
+	peer := 0
+	for i := range cfg.Mode6.Peers {
+		if peerSelectCode(cfg.Mode6.Peers, i) == 6 {
+			peer = i + 1
+			break
+		}
+	}
+	vars := []ctlVar{
+		{"version", `"ntpd 4.2.8p15@1.3728-o (fake-ntpd)"`},
+		{"processor", `"x86_64"`},
+		{"system", `"Linux"`},
+		{"leap", strconv.Itoa(cfg.LeapIndicator)},
+		{"stratum", strconv.Itoa(int(stratum))},
+		{"precision", strconv.Itoa(int(int8(v4[3])))},
+		{"rootdelay", fmt.Sprintf("%.3f", ms(shortToDuration(binary.BigEndian.Uint32(v4[4:]))))},
+		{"rootdisp", fmt.Sprintf("%.3f", ms(shortToDuration(binary.BigEndian.Uint32(v4[8:]))))},
+		{"refid", refIDText(binary.BigEndian.Uint32(v4[12:]), stratum)},
+		{"reftime", fmt.Sprintf("0x%08x.%08x", binary.BigEndian.Uint32(v4[16:]), binary.BigEndian.Uint32(v4[20:]))},
+		{"clock", ntpHex(served)},
+		{"peer", strconv.Itoa(peer)},
+		{"tc", "6"},
+		{"mintc", "3"},
+		// Gezien vanaf de server: zijn klok loopt zoveel voor, dus de peer
+		// zoveel achter
+		{"offset", fmt.Sprintf("%.6f", -ms(served.Sub(now)))},
+		{"frequency", "0.000"},
+		{"sys_jitter", "0.000"},
+		{"clk_jitter", "0.000"},
+		{"clk_wander", "0.000"},
+	}
+	return withVars(vars, cfg.Mode6.System)
+}
+
+// refIDText is de refid zoals ntpq hem toont: tekst bij stratum 0, 1 en
+// 16, anders als IPv4-adres (ook de hash van een IPv6-adres).
+func refIDText(refID uint32, stratum uint8) string {
+	b := [4]byte(binary.BigEndian.AppendUint32(nil, refID))
+	switch stratum {
+	case 0, 1, 16:
+		return strings.TrimRight(string(b[:]), "\x00")
+	}
+	return netip.AddrFrom4(b).String()
+}
+
+func (s *Server) peerVars(cfg Config, i int, now time.Time) []ctlVar {
+	served := servedTime(cfg, now)
+	vars := []ctlVar{
+		{"srcadr", fmt.Sprintf("192.0.2.%d", i+1)},
+		{"srcport", "123"},
+		{"dstadr", s.conn.LocalAddr().(*net.UDPAddr).IP.String()},
+		{"dstport", strconv.Itoa(s.conn.LocalAddr().(*net.UDPAddr).Port)},
+		{"leap", "0"},
+		{"stratum", "1"},
+		{"precision", "-20"},
+		{"rootdelay", "0.000"},
+		{"rootdisp", "0.000"},
+		{"refid", "GPS"},
+		{"reftime", ntpHex(served.Add(-16 * time.Second))},
+		{"rec", ntpHex(served.Add(-16 * time.Second))},
+		{"reach", "377"},
+		{"unreach", "0"},
+		{"hmode", "3"},
+		{"pmode", "4"},
+		{"hpoll", "6"},
+		{"ppoll", "6"},
+		{"headway", "0"},
+		{"flash", "0x0"},
+		{"keyid", "0"},
+		{"offset", "0.000"},
+		{"delay", "0.000"},
+		{"dispersion", "0.000"},
+		{"jitter", "0.000"},
+		{"xleave", "0.000"},
+	}
+	return withVars(vars, cfg.Mode6.Peers[i].Vars)
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func peerSelectCode(peers []Mode6Peer, i int) int {
+	sel := peers[i].Select
+	if sel == "" {
+		sel = "candidate"
+		if i == 0 {
+			sel = "sys_peer"
+		}
+	}
+	return slices.Index(peerSelect, sel)
+}
+
+// systemStatus: leap indicator, klokbron (4 = radio, 6 = NTP) en geen
+// events.
+func systemStatus(cfg Config) uint16 {
+	source := 6
+	if cfg.MinStratum == 1 {
+		source = 4
+	}
+	return uint16(cfg.LeapIndicator&0x03)<<14 | uint16(source)<<8
+}
+
+// respondMode6 beantwoordt een control-verzoek, zo nodig in fragmenten.
+func (s *Server) respondMode6(ex *exchange, req []byte, clientAddr *net.UDPAddr, cfg Config) {
+	if len(req) < ctlHeaderSize || req[1]&0x80 != 0 {
+		s.recordDrop(ex, "mode") // te kort, of zelf een antwoord
+		return
+	}
+	op := req[1] & 0x1f
+	assoc := binary.BigEndian.Uint16(req[6:])
+	count := int(binary.BigEndian.Uint16(req[10:]))
+	data := req[ctlHeaderSize:min(len(req), ctlHeaderSize+count)]
+	now := time.Now()
+
+	var body []byte
+	var status uint16
+	var errCode int
+	switch {
+	case op == ctlOpReadStat && assoc == 0:
+		status = systemStatus(cfg)
+		for i := range cfg.Mode6.Peers {
+			st := uint16(ctlPeerConfigured|ctlPeerReachable|peerSelectCode(cfg.Mode6.Peers, i)) << 8
+			body = binary.BigEndian.AppendUint16(body, uint16(i+1))
+			body = binary.BigEndian.AppendUint16(body, st)
+		}
+	case op == ctlOpReadVar && assoc == 0:
+		status = systemStatus(cfg)
+		body = varsText(s.systemVars(cfg, now), requestedVars(data))
+	case op == ctlOpReadStat || op == ctlOpReadVar:
+		i := int(assoc) - 1
+		if i < 0 || i >= len(cfg.Mode6.Peers) {
+			errCode = ctlErrBadAssoc
+			break
+		}
+		status = uint16(ctlPeerConfigured|ctlPeerReachable|peerSelectCode(cfg.Mode6.Peers, i)) << 8
+		body = varsText(s.peerVars(cfg, i, now), requestedVars(data))
+	case op >= 3 && op <= 9:
+		errCode = ctlErrPermission // writevar, setclock, config enzovoort
+	default:
+		errCode = ctlErrBadOp
+	}
+	if cfg.Debug {
+		fmt.Printf("Mode 6-verzoek van %s: opcode %d, association %d\n", clientAddr.IP.String(), op, assoc)
+	}
+
+	header := func(more bool, offset, n int) []byte {
+		h := make([]byte, ctlHeaderSize, ctlHeaderSize+n+3)
+		h[0] = uint8(cfg.LeapIndicator&0x03)<<6 | req[0]&0x38 | 6
+		h[1] = 0x80 | op // response
+		if more {
+			h[1] |= 0x20
+		}
+		copy(h[2:4], req[2:4]) // sequence
+		binary.BigEndian.PutUint16(h[4:], status)
+		binary.BigEndian.PutUint16(h[6:], assoc)
+		binary.BigEndian.PutUint16(h[8:], uint16(offset))
+		binary.BigEndian.PutUint16(h[10:], uint16(n))
+		return h
+	}
+
+	if errCode != 0 {
+		resp := header(false, 0, 0)
+		resp[1] |= 0x40
+		binary.BigEndian.PutUint16(resp[4:], uint16(errCode)<<8)
+		ex.action, ex.reason, ex.resp = "mode6", "error", resp
+		ex.lost, _ = s.send(resp, clientAddr, cfg)
+		s.record(ex)
+		return
+	}
+
+	ex.action, ex.reason = "mode6", strconv.Itoa(int(op))
+	for offset := 0; offset == 0 || offset < len(body); offset += ctlMaxData {
+		chunk := body[offset:min(len(body), offset+ctlMaxData)]
+		resp := append(header(offset+len(chunk) < len(body), offset, len(chunk)), chunk...)
+		resp = append(resp, make([]byte, padTo4(len(resp))-len(resp))...)
+		if offset == 0 {
+			ex.resp = resp
+		}
+		if lost, _ := s.send(resp, clientAddr, cfg); lost {
+			ex.lost = true
+		}
+	}
+	s.record(ex)
+}
+
+// requestedVars haalt de namen uit een lijst als "srcadr,refid,offset".
+func requestedVars(data []byte) []string {
+	var names []string
+	for _, f := range strings.Split(string(data), ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(strings.TrimRight(f, "\x00")), "=")
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
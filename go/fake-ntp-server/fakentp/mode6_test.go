@@ -0,0 +1,97 @@
+package fakentp_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/beevik/ntp"
+
+	"fake-ntp-server/fakentp"
+)
+
+// TestMode6SystemVars: `ntpq -c rv` toont dezelfde stratum, precision,
+// refid en root delay als de mode 4-antwoorden, ook met ranges en met
+// hierarchy.
+func TestMode6SystemVars(t *testing.T) {
+	t.Run("ranges", func(t *testing.T) {
+		cfg := fakentp.DefaultConfig()
+		cfg.Mode6.Enabled = true
+		cfg.MinStratum, cfg.MaxStratum = 2, 4
+		cfg.MinPrecision, cfg.MaxPrecision = -25, -18
+		seed := int64(1)
+		cfg.Seed = &seed
+		srv, addr, err := fakentp.Start(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+
+		strata := map[int]bool{}
+		for range 20 {
+			vars := readSystemVars(t, addr)
+			stratum, _ := strconv.Atoi(vars["stratum"])
+			precision, _ := strconv.Atoi(vars["precision"])
+			if stratum < 2 || stratum > 4 || precision < -25 || precision > -18 {
+				t.Fatalf("stratum %q, precision %q buiten de ranges", vars["stratum"], vars["precision"])
+			}
+			if strings.Count(vars["refid"], ".") != 3 {
+				t.Errorf("refid %q bij stratum %d, verwacht een IPv4-adres", vars["refid"], stratum)
+			}
+			strata[stratum] = true
+		}
+		if len(strata) < 2 {
+			t.Errorf("altijd stratum %v, verwacht een trekking uit 2–4", strata)
+		}
+	})
+
+	t.Run("hierarchy", func(t *testing.T) {
+		cfg := fakentp.DefaultConfig()
+		cfg.Mode6.Enabled = true
+		cfg.MinStratum, cfg.MaxStratum = 3, 3
+		cfg.Hierarchy.Peer = "192.0.2.1"
+		srv, addr, err := fakentp.Start(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+
+		resp, err := ntp.Query(addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars := readSystemVars(t, addr)
+		if vars["stratum"] != "3" || vars["refid"] != resp.ReferenceString() || vars["refid"] != "192.0.2.1" {
+			t.Errorf("stratum %s, refid %s; mode 4: stratum %d, refid %s", vars["stratum"], vars["refid"], resp.Stratum, resp.ReferenceString())
+		}
+		// Twee hops van 10 ms, in NTP short format net als in het pakket
+		if want := fmt.Sprintf("%.3f", resp.RootDelay.Seconds()*1000); vars["rootdelay"] != want || want != "20.004" {
+			t.Errorf("rootdelay %s, mode 4: %s, verwacht 20.004", vars["rootdelay"], want)
+		}
+	})
+}
+
+// readSystemVars doet een readvar op association 0, zoals `ntpq -c rv`.
+func readSystemVars(t *testing.T, addr *net.UDPAddr) map[string]string {
+	t.Helper()
+	resp, err := roundTrip(addr, []byte{0x16, 2, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) < 12 || resp[1]&0x20 != 0 {
+		t.Fatalf("geen antwoord op readvar, of meer fragmenten: %x", resp)
+	}
+	count := int(binary.BigEndian.Uint16(resp[10:]))
+	if 12+count > len(resp) {
+		t.Fatalf("count %d, maar %d bytes data", count, len(resp)-12)
+	}
+	vars := map[string]string{}
+	for _, item := range strings.Split(string(resp[12:12+count]), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+		vars[name] = value
+	}
+	return vars
+}
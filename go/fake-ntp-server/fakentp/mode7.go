@@ -0,0 +1,205 @@
+package fakentp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Mode 7 (private, ntpdc): alleen monlist. Een server die monlist
+// beantwoordt is bruikbaar voor DDoS-amplificatie (CVE-2013-5211); hiermee
+// is in het lab te testen of scanners dat zien. De lijst bevat de clients
+// die de server echt gezien heeft, aangevuld met verzonnen adressen:
+//
+//	"mode7": {"monlist": true, "fake_entries": 600}
+//
+// Alle andere mode 7-verzoeken krijgen een foutmelding.
+
+type Mode7Config struct {
+	Monlist     bool `json:"monlist"`
+	FakeEntries int  `json:"fake_entries"` // extra clients uit 198.51.100.0/24 en 203.0.113.0/24
+}
+
+const (
+	reqMonGetList1   = 42 // REQ_MON_GETLIST_1
+	implXNTPD        = 3
+	infoErrImpl      = 1
+	infoErrReq       = 2
+	monitorMax       = 600 // zoals ntpd
+	infoMonitor1Size = 72
+	mode7MaxData     = 500
+	mode7HeaderSize  = 8
+)
+
+func validateMode7(cfg Mode7Config) error {
+	if cfg.FakeEntries < 0 || cfg.FakeEntries > 2*254 {
+		return fmt.Errorf("Mode 7: fake_entries moet 0–508 zijn")
+	}
+	return nil
+}
+
+// monitor houdt de recente clients bij, zoals de MRU-lijst van ntpd.
+type monitor struct {
+	mu      sync.Mutex
+	clients map[netip.Addr]*monEntry
+}
+
+type monEntry struct {
+	addr          netip.Addr
+	port          uint16
+	mode, version uint8
+	first, last   time.Time
+	count         uint32
+}
+
+func newMonitor() *monitor {
+	return &monitor{clients: make(map[netip.Addr]*monEntry)}
+}
+
+func (m *monitor) seen(clientAddr *net.UDPAddr, req []byte, now time.Time) {
+	addr := addrOf(clientAddr)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.clients[addr]
+	if !ok {
+		if len(m.clients) >= 2*monitorMax {
+			m.prune()
+		}
+		e = &monEntry{addr: addr, first: now}
+		m.clients[addr] = e
+	}
+	e.port = uint16(clientAddr.Port)
+	e.mode, e.version = req[0]&0x07, req[0]>>3&0x07
+	e.last = now
+	e.count++
+}
+
+// prune houdt de monitorMax meest recente clients over.
+func (m *monitor) prune() {
+	for _, e := range m.recent()[monitorMax:] {
+		delete(m.clients, e.addr)
+	}
+}
+
+// recent geeft kopieën van de clients, de laatst geziene eerst. Alleen
+// aanroepen met m.mu vast: seen werkt de entries bij vanuit andere workers.
+func (m *monitor) recent() []monEntry {
+	entries := make([]monEntry, 0, len(m.clients))
+	for _, e := range m.clients {
+		entries = append(entries, *e)
+	}
+	slices.SortFunc(entries, func(a, b monEntry) int { return b.last.Compare(a.last) })
+	return entries
+}
+
+// list geeft hoogstens monitorMax clients, met verzonnen clients erbij.
+func (m *monitor) list(fake int, now time.Time) []monEntry {
+	m.mu.Lock()
+	entries := m.recent()
+	m.mu.Unlock()
+	for i := 0; i < fake; i++ {
+		prefix := [3]byte{198, 51, 100}
+		if i >= 254 {
+			prefix = [3]byte{203, 0, 113}
+		}
+		entries = append(entries, monEntry{
+			addr:    netip.AddrFrom4([4]byte{prefix[0], prefix[1], prefix[2], byte(i%254 + 1)}),
+			port:    uint16(1024 + i),
+			mode:    3,
+			version: 4,
+			first:   now.Add(-time.Duration(3600+i*7) * time.Second),
+			last:    now.Add(-time.Duration(i*5) * time.Second),
+			count:   uint32(10 + i),
+		})
+	}
+	return entries[:min(len(entries), monitorMax)]
+}
+
+// respondMode7 beantwoordt REQ_MON_GETLIST_1 met info_monitor_1-records,
+// zes per pakket.
+func (s *Server) respondMode7(ex *exchange, req []byte, clientAddr *net.UDPAddr, cfg Config) {
+	if len(req) < mode7HeaderSize || req[0]&0x80 != 0 {
+		s.recordDrop(ex, "mode") // te kort, of zelf een antwoord
+		return
+	}
+	impl, code := req[2], req[3]
+	if cfg.Debug {
+		fmt.Printf("Mode 7-verzoek van %s: implementation %d, request %d\n", clientAddr.IP.String(), impl, code)
+	}
+
+	header := func(seq int, more bool, errCode, n, size int) []byte {
+		h := make([]byte, mode7HeaderSize, mode7HeaderSize+n*size)
+		h[0] = 0x80 | req[0]&0x38 | 7 // response
+		if more {
+			h[0] |= 0x40
+		}
+		h[1] = byte(seq & 0x7f)
+		h[2], h[3] = impl, code
+		binary.BigEndian.PutUint16(h[4:], uint16(errCode)<<12|uint16(n))
+		binary.BigEndian.PutUint16(h[6:], uint16(size))
+		return h
+	}
+
+	errCode := 0
+	switch {
+	case impl != implXNTPD:
+		errCode = infoErrImpl
+	case code != reqMonGetList1 || !cfg.Mode7.Monlist || s.monitor == nil:
+		errCode = infoErrReq
+	}
+	if errCode != 0 {
+		resp := header(0, false, errCode, 0, 0)
+		ex.action, ex.reason, ex.resp = "mode7", "error", resp
+		ex.lost, _ = s.send(resp, clientAddr, cfg)
+		s.record(ex)
+		return
+	}
+
+	now := time.Now()
+	entries := s.monitor.list(cfg.Mode7.FakeEntries, now)
+	perPacket := mode7MaxData / infoMonitor1Size
+	ex.action, ex.reason = "mode7", "monlist"
+	for seq, start := 0, 0; start == 0 || start < len(entries); seq, start = seq+1, start+perPacket {
+		chunk := entries[start:min(len(entries), start+perPacket)]
+		resp := header(seq, start+len(chunk) < len(entries), 0, len(chunk), infoMonitor1Size)
+		for _, e := range chunk {
+			resp = appendInfoMonitor1(resp, e, now)
+		}
+		if seq == 0 {
+			ex.resp = resp
+		}
+		if lost, _ := s.send(resp, clientAddr, cfg); lost {
+			ex.lost = true
+		}
+	}
+	s.record(ex)
+}
+
+// appendInfoMonitor1 voegt een struct info_monitor_1 (72 bytes) toe.
+func appendInfoMonitor1(b []byte, e monEntry, now time.Time) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(now.Sub(e.last)/time.Second))  // lasttime
+	b = binary.BigEndian.AppendUint32(b, uint32(now.Sub(e.first)/time.Second)) // firsttime
+	b = binary.BigEndian.AppendUint32(b, 0)                                    // restr
+	b = binary.BigEndian.AppendUint32(b, e.count)
+	var addr4 [4]byte
+	var addr6 [16]byte
+	v6 := uint32(0)
+	if e.addr.Is4() {
+		addr4 = e.addr.As4()
+	} else {
+		addr6, v6 = e.addr.As16(), 1
+	}
+	b = append(b, addr4[:]...)
+	b = binary.BigEndian.AppendUint32(b, 0) // daddr
+	b = binary.BigEndian.AppendUint32(b, 0) // flags
+	b = binary.BigEndian.AppendUint16(b, e.port)
+	b = append(b, e.mode, e.version)
+	b = binary.BigEndian.AppendUint32(b, v6)
+	b = binary.BigEndian.AppendUint32(b, 0) // unused1
+	b = append(b, addr6[:]...)
+	return append(b, make([]byte, 16)...) // daddr6
+}
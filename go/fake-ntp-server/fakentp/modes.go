@@ -0,0 +1,97 @@
+package fakentp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Symmetric en broadcast mode, voor oudere apparatuur.
+//
+// Met "symmetric": true beantwoordt de server een symmetric active peer
+// (mode 1) als symmetric passive (mode 2), met dezelfde timestamps als een
+// gewoon antwoord. Zelf begint hij geen associaties, dus mode 2-pakketten
+// worden genegeerd. Een MAC (symmetric key of autokey) wordt niet
+// gecontroleerd en niet meegestuurd.
+//
+// Met "broadcast" stuurt de server elke interval_sec een mode 5-pakket naar
+// een (broadcast- of multicast)adres, vanaf zijn eigen poort:
+//
+//	"broadcast": {"address": "192.0.2.255:123", "interval_sec": 64}
+
+type BroadcastConfig struct {
+	Address     string  `json:"address"`      // ip:poort; leeg = uit
+	IntervalSec float64 `json:"interval_sec"` // standaard 64
+}
+
+func validateBroadcast(cfg BroadcastConfig) error {
+	if cfg.Address != "" {
+		if _, err := netip.ParseAddrPort(cfg.Address); err != nil {
+			return fmt.Errorf("Broadcast: ongeldig adres %q (ip:poort): %v", cfg.Address, err)
+		}
+	}
+	if cfg.IntervalSec < 0 {
+		return fmt.Errorf("Broadcast: interval_sec moet >= 0 zijn")
+	}
+	return nil
+}
+
+// broadcast stuurt de broadcast-pakketten; het adres en de interval liggen
+// vast bij het starten, de rest van de config volgt scenario en control API.
+func (s *Server) broadcast(bc BroadcastConfig) {
+	interval := 64 * time.Second
+	if bc.IntervalSec > 0 {
+		interval = time.Duration(bc.IntervalSec * float64(time.Second))
+	}
+	// Al gevalideerd
+	dst := net.UDPAddrFromAddrPort(netip.MustParseAddrPort(bc.Address))
+	// De poll in het pakket is de interval, als macht van 2
+	poll := int8(max(0, math.Round(math.Log2(interval.Seconds()))))
+	log.Printf("Broadcast naar %v, elke %v", dst, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := time.Now(); ; {
+		s.sendBroadcast(dst, poll, now)
+		select {
+		case now = <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sendBroadcast stuurt één broadcast-pakket, tenzij de server gepauzeerd
+// is of niet antwoordt.
+func (s *Server) sendBroadcast(dst *net.UDPAddr, poll int8, now time.Time) {
+	if s.paused.Load() {
+		return
+	}
+	cfg := s.configFor(now, netip.Addr{})
+	if cfg.Drop {
+		return
+	}
+	cfg.drawJitter()
+	// Er is geen verzoek; een leeg verzoek geeft origin 0
+	req := make([]byte, NtpPacketSize)
+	resp := createFakeNTPResponse(req, cfg, now)
+	resp[0] = resp[0]&^0x07 | 5
+	resp[2] = byte(poll)
+	clear(resp[32:40]) // geen receive timestamp
+	stampTransmitTime(resp, cfg)
+
+	if _, err := s.conn.WriteToUDP(resp, dst); err != nil {
+		if !errors.Is(err, net.ErrClosed) {
+			log.Printf("Fout bij broadcast naar %v: %v", dst, err)
+		}
+		return
+	}
+	s.metrics.responses.WithLabelValues(s.name, "broadcast").Inc()
+	if cfg.Debug {
+		fmt.Printf("Broadcast naar %v\n", dst)
+	}
+}
@@ -0,0 +1,362 @@
+package fakentp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// NTPv5 (draft-ietf-ntp-ntpv5-09, dezelfde versie als beevik/ntp). Een
+// v5-verzoek krijgt een antwoord in de v5-layout:
+//
+//	0  LI/VN/Mode, Stratum, Poll, Precision
+//	4  Root Delay (Q4.28)
+//	8  Root Dispersion (Q4.28)
+//	12 Timescale, Era, Flags
+//	16 Server Cookie
+//	24 Client Cookie
+//	32 Receive Timestamp
+//	40 Transmit Timestamp
+//
+// met daarachter de gevraagde extension fields. Stratum, poll, precision,
+// leap indicator enzovoort komen uit createFakeNTPResponse, zodat de rest
+// van de Config (scenario, client-profielen) ook voor v5 geldt.
+
+type NTPv5Config struct {
+	// Zonder enabled krijgen v5-verzoeken het gewone v4-antwoord
+	Enabled bool `json:"enabled"`
+
+	// Header-velden. Zonder timescale volgt de server de timescale uit het
+	// verzoek; zonder era wordt die uit de tijd berekend; synchronized
+	// volgt standaard de leap indicator (3 = niet gesynchroniseerd).
+	Timescale    *int   `json:"timescale"` // 0 UTC, 1 TAI, 2 UT1, 3 leap-smeared UTC
+	Era          *int   `json:"era"`
+	Synchronized *bool  `json:"synchronized"`
+	AuthNAK      bool   `json:"auth_nak"`    // flag "authentication NAK" zetten
+	ExtraFlags   uint16 `json:"extra_flags"` // worden bij de flags ge-OR'd
+
+	// Server cookie; 0 = automatisch (alleen met interleaved, anders 0)
+	ServerCookie uint64 `json:"server_cookie"`
+	// Stuur een ander client cookie terug dan de client stuurde
+	WrongClientCookie bool `json:"wrong_client_cookie"`
+
+	// Verschil met UTC voor de andere timescales
+	TAIOffset   int `json:"tai_offset"`    // seconden, standaard 37
+	UT1OffsetMs int `json:"ut1_offset_ms"` // milliseconden
+
+	// Reference ID (120 bits, 30 hex-tekens) voor de Bloom filter; leeg =
+	// willekeurig per start. UpstreamRefIDs komen er ook in, alsof de
+	// server die bronnen volgt.
+	RefID          string   `json:"ref_id"`
+	UpstreamRefIDs []string `json:"upstream_ref_ids"`
+
+	SupportedVersions []int  `json:"supported_versions"` // standaard 4 en 5
+	MonotonicEpoch    uint32 `json:"monotonic_epoch"`
+	DraftID           string `json:"draft_id"` // standaard draft-ietf-ntp-ntpv5-09
+}
+
+const (
+	ntpv5DraftID = "draft-ietf-ntp-ntpv5-09"
+
+	v5FlagSynchronized = 1 << 0
+	v5FlagInterleaved  = 1 << 1
+	v5FlagAuthNAK      = 1 << 2
+
+	v5TimescaleUTC     = 0
+	v5TimescaleTAI     = 1
+	v5TimescaleUT1     = 2
+	v5TimescaleSmeared = 3
+
+	// NTPv5 extension field types
+	efV5Padding    = 0xF501
+	efV5MAC        = 0xF502
+	efV5RefIDReq   = 0xF503
+	efV5RefIDResp  = 0xF504
+	efV5ServerInfo = 0xF505
+	efV5Correction = 0xF506
+	efV5RefTime    = 0xF507
+	efV5Monotonic  = 0xF508
+	efV5Secondary  = 0xF509
+	efV5DraftID    = 0xF5FF
+
+	refIDv5Size     = 15  // 120 bits
+	refIDFilterSize = 512 // 4096 bits
+)
+
+func validateNTPv5(cfg NTPv5Config) error {
+	if cfg.Timescale != nil && (*cfg.Timescale < 0 || *cfg.Timescale > 255) {
+		return fmt.Errorf("Ongeldige NTPv5-timescale: %d (moet 0–255 zijn)", *cfg.Timescale)
+	}
+	if cfg.Era != nil && (*cfg.Era < 0 || *cfg.Era > 255) {
+		return fmt.Errorf("Ongeldige NTPv5-era: %d (moet 0–255 zijn)", *cfg.Era)
+	}
+	for _, id := range append([]string{cfg.RefID}, cfg.UpstreamRefIDs...) {
+		if id == "" {
+			continue
+		}
+		if _, err := parseRefIDv5(id); err != nil {
+			return err
+		}
+	}
+	for _, v := range cfg.SupportedVersions {
+		if v < 1 || v > 16 {
+			return fmt.Errorf("Ongeldige NTP-versie in supported_versions: %d", v)
+		}
+	}
+	return nil
+}
+
+func parseRefIDv5(s string) ([refIDv5Size]byte, error) {
+	var id [refIDv5Size]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != refIDv5Size {
+		return id, fmt.Errorf("Ongeldige NTPv5 reference ID %q (moet 30 hex-tekens zijn)", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+func randomRefIDv5(r *rand.Rand) [refIDv5Size]byte {
+	var id [refIDv5Size]byte
+	r.Read(id[:])
+	return id
+}
+
+// refIDFilter bouwt de Bloom filter: elke 120-bit reference ID zet tien
+// bits, één per 12-bit stuk van de ID. Bits zijn MSB-eerst genummerd.
+func refIDFilter(ids [][refIDv5Size]byte) []byte {
+	filter := make([]byte, refIDFilterSize)
+	for _, id := range ids {
+		for i := 0; i < 10; i++ {
+			// 12 bits vanaf bit 12*i: anderhalve byte
+			off := i * 3 / 2
+			v := uint16(id[off])<<8 | uint16(id[off+1])
+			if i%2 == 0 {
+				v >>= 4
+			}
+			v &= 0x0fff
+			filter[v/8] |= 0x80 >> (v % 8)
+		}
+	}
+	return filter
+}
+
+// timescaleShift is het verschil tussen een timescale en UTC.
+func timescaleShift(cfg NTPv5Config, ts uint8) time.Duration {
+	switch ts {
+	case v5TimescaleTAI:
+		if cfg.TAIOffset == 0 {
+			return 37 * time.Second
+		}
+		return time.Duration(cfg.TAIOffset) * time.Second
+	case v5TimescaleUT1:
+		return time.Duration(cfg.UT1OffsetMs) * time.Millisecond
+	}
+	return 0
+}
+
+// eraOf geeft de NTP-era van t: 0 tot 2036, daarna 1, enzovoort.
+func eraOf(t time.Time) uint8 {
+	return uint8((t.Unix() + NtpEpochOffset) >> 32)
+}
+
+func v5Timestamp(t time.Time) uint64 {
+	sec, frac := ntpTimestampParts(t)
+	return uint64(sec)<<32 | uint64(frac)
+}
+
+// handleV5 beantwoordt een NTPv5-verzoek.
+func (s *Server) handleV5(req []byte, clientAddr *net.UDPAddr, clientIP netip.Addr, cfg Config, ex *exchange) {
+	v5 := cfg.NTPv5
+	rxTime := ex.rxTime
+
+	// Timescale: die van het verzoek, tenzij vastgezet. Onbekende
+	// timescales worden UTC.
+	ts := req[12]
+	if v5.Timescale != nil {
+		ts = uint8(*v5.Timescale)
+	} else if ts > v5TimescaleSmeared {
+		ts = v5TimescaleUTC
+	}
+	// De hele klok van het antwoord schuift mee met de timescale
+	tsCfg := cfg
+	tsCfg.TimeOffsetMs -= int(timescaleShift(v5, ts) / time.Millisecond)
+
+	v4 := createFakeNTPResponse(req, tsCfg, rxTime)
+	rxServed := servedTime(tsCfg, rxTime)
+
+	resp := make([]byte, NtpPacketSize, MaxPacketSize)
+	resp[0] = v4[0]&0xc7 | 5<<3
+	copy(resp[1:4], v4[1:4])
+	// Root delay/dispersion: van NTP short (16.16) naar Q4.28
+	binary.BigEndian.PutUint32(resp[4:], satShift12(binary.BigEndian.Uint32(v4[4:])))
+	binary.BigEndian.PutUint32(resp[8:], satShift12(binary.BigEndian.Uint32(v4[8:])))
+	resp[12] = ts
+	resp[13] = eraOf(rxServed)
+	if v5.Era != nil {
+		resp[13] = uint8(*v5.Era)
+	}
+
+	var flags uint16
+	synced := cfg.LeapIndicator != 3
+	if v5.Synchronized != nil {
+		synced = *v5.Synchronized
+	}
+	if synced {
+		flags |= v5FlagSynchronized
+	}
+	if v5.AuthNAK {
+		flags |= v5FlagAuthNAK
+	}
+	flags |= v5.ExtraFlags
+
+	interleaved := s.interleaved != nil && cfg.Interleaved
+	if interleaved {
+		// De receive timestamp dient als server cookie, net als de origin
+		// timestamp in v4 interleaved mode
+		copy(resp[16:24], v4[32:40])
+	}
+	if v5.ServerCookie != 0 {
+		binary.BigEndian.PutUint64(resp[16:], v5.ServerCookie)
+	}
+
+	copy(resp[24:32], req[24:32])
+	if v5.WrongClientCookie {
+		resp[31] ^= 0xff
+	}
+	copy(resp[32:40], v4[32:40])
+
+	rxUTC := servedTime(cfg, rxTime)
+	resp = s.appendV5Extensions(resp, req, v5, v4, rxUTC, rxTime)
+
+	txTime, txServed := stampTransmitTime(resp, tsCfg)
+	if interleaved && binary.BigEndian.Uint16(req[14:])&v5FlagInterleaved != 0 {
+		cookie := binary.BigEndian.Uint64(req[16:24])
+		if prevTx, ok := s.interleaved.previousTx(clientIP, cookie); ok {
+			flags |= v5FlagInterleaved
+			binary.BigEndian.PutUint64(resp[40:], v5Timestamp(prevTx))
+			if cfg.Debug {
+				fmt.Printf("  - NTPv5 interleaved antwoord, vorige T3: %s\n", prevTx.UTC().Format(time.RFC3339Nano))
+			}
+		}
+	}
+	binary.BigEndian.PutUint16(resp[14:], flags)
+
+	ex.action, ex.txTime = "response", txTime
+	if len(cfg.Malform) > 0 {
+		s.sendMalformed(ex, resp, clientAddr, cfg)
+		return
+	}
+
+	lost, err := s.send(resp, clientAddr, cfg)
+	if err != nil && cfg.Debug {
+		log.Printf("Fout bij versturen: %v", err)
+	}
+	if err == nil && interleaved {
+		s.interleaved.sent(clientIP, resp, txTime, txServed)
+	}
+	ex.resp, ex.lost = resp, lost
+	s.record(ex)
+}
+
+// appendV5Extensions beantwoordt de extension fields uit het verzoek. Een
+// correction field moet als laatste, dat gaat ongewijzigd terug.
+// rxUTC is de uitgezonden ontvangsttijd in UTC, rxTime die van de
+// eigen klok.
+func (s *Server) appendV5Extensions(resp, req []byte, v5 NTPv5Config, v4 []byte, rxUTC, rxTime time.Time) []byte {
+	var correction []byte
+	for pos := NtpPacketSize; pos+4 <= len(req); {
+		typ := binary.BigEndian.Uint16(req[pos:])
+		length := int(binary.BigEndian.Uint16(req[pos+2:]))
+		if length < 4 || pos+length > len(req) {
+			break
+		}
+		body := req[pos+4 : pos+length]
+		pos += padTo4(length)
+
+		switch typ {
+		case efV5DraftID:
+			id := v5.DraftID
+			if id == "" {
+				id = ntpv5DraftID
+			}
+			resp = appendEF(resp, efV5DraftID, []byte(id))
+
+		case efV5RefIDReq:
+			// Body: offset (2 bytes), aangevuld tot de gevraagde grootte
+			if len(body) < 2 {
+				continue
+			}
+			off := int(binary.BigEndian.Uint16(body))
+			size := len(body)
+			if off+size > refIDFilterSize {
+				continue
+			}
+			resp = appendEF(resp, efV5RefIDResp, s.refIDFilter(v5)[off:off+size])
+
+		case efV5ServerInfo:
+			versions := v5.SupportedVersions
+			if len(versions) == 0 {
+				versions = []int{4, 5}
+			}
+			var bits uint16
+			for _, v := range versions {
+				bits |= 1 << (v - 1)
+			}
+			resp = appendEF(resp, efV5ServerInfo, []byte{byte(bits >> 8), byte(bits), 0, 0})
+
+		case efV5RefTime:
+			resp = appendEF(resp, efV5RefTime, v4[16:24])
+
+		case efV5Monotonic:
+			// Monotone klok: de eigen klok zonder time_offset_ms
+			b := binary.BigEndian.AppendUint32(nil, v5.MonotonicEpoch)
+			b = binary.BigEndian.AppendUint64(b, v5Timestamp(rxTime))
+			resp = appendEF(resp, efV5Monotonic, b)
+
+		case efV5Secondary:
+			if len(body) < 1 || body[0] > v5TimescaleSmeared {
+				continue
+			}
+			t := rxUTC.Add(timescaleShift(v5, body[0]))
+			b := []byte{body[0], eraOf(t), 0, 0}
+			b = binary.BigEndian.AppendUint64(b, v5Timestamp(t))
+			resp = appendEF(resp, efV5Secondary, b)
+
+		case efV5Correction:
+			correction = body
+		}
+	}
+	if correction != nil {
+		resp = appendEF(resp, efV5Correction, correction)
+	}
+	return resp
+}
+
+// refIDFilter geeft de Bloom filter met de eigen reference ID en die van
+// de upstream-bronnen. Alles is al gevalideerd in validateConfig.
+func (s *Server) refIDFilter(v5 NTPv5Config) []byte {
+	own := s.refIDv5
+	if v5.RefID != "" {
+		own, _ = parseRefIDv5(v5.RefID)
+	}
+	ids := [][refIDv5Size]byte{own}
+	for _, u := range v5.UpstreamRefIDs {
+		id, _ := parseRefIDv5(u)
+		ids = append(ids, id)
+	}
+	return refIDFilter(ids)
+}
+
+// satShift12 zet een 16.16-waarde om naar 4.28, met verzadiging.
+func satShift12(v uint32) uint32 {
+	if v >= 1<<20 {
+		return 0xffffffff
+	}
+	return v << 12
+}
@@ -0,0 +1,203 @@
+package fakentp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"fake-ntp-server/fakentp"
+)
+
+// NTPv5 extension field types (draft-ietf-ntp-ntpv5-09)
+const (
+	efRefIDReq   = 0xF503
+	efRefIDResp  = 0xF504
+	efServerInfo = 0xF505
+	efCorrection = 0xF506
+	efDraftID    = 0xF5FF
+)
+
+// TestNTPv5Extensions: per extension field het antwoord, uit de ruwe
+// bytes gelezen in plaats van via een client.
+func TestNTPv5Extensions(t *testing.T) {
+	t.Run("reference ID Bloom filter", func(t *testing.T) {
+		cfg := v5Config()
+		// Tien 12-bit stukken 0..9 en één keer 0xfff: bits 0–9 en 4095
+		cfg.NTPv5.RefID = "000001002003004005006007008009"
+		cfg.NTPv5.UpstreamRefIDs = []string{"ffffffffffffffffffffffffffffff"}
+
+		want := make([]byte, 512)
+		want[0], want[1], want[511] = 0xff, 0xc0, 0x01
+
+		efs := v5Query(t, cfg, ef{efRefIDReq, make([]byte, 512)})
+		if got := efs.get(t, efRefIDResp); !bytes.Equal(got, want) {
+			t.Errorf("filter %x, verwacht %x", got, want)
+		}
+
+		// Een deel van het filter: offset 508, 4 bytes
+		efs = v5Query(t, cfg, ef{efRefIDReq, []byte{0x01, 0xfc, 0, 0}})
+		if got := efs.get(t, efRefIDResp); !bytes.Equal(got, want[508:]) {
+			t.Errorf("filter[508:] %x, verwacht %x", got, want[508:])
+		}
+
+		// Voorbij het einde van het filter: geen antwoord
+		efs = v5Query(t, cfg, ef{efRefIDReq, []byte{0x01, 0xfe, 0, 0}})
+		if _, ok := efs.body[efRefIDResp]; ok {
+			t.Error("antwoord op een verzoek voorbij het einde van het filter")
+		}
+	})
+
+	t.Run("supported versions", func(t *testing.T) {
+		for _, tc := range []struct {
+			versions []int
+			bits     uint16
+		}{
+			{nil, 1<<3 | 1<<4},
+			{[]int{3, 4, 5}, 1<<2 | 1<<3 | 1<<4},
+		} {
+			cfg := v5Config()
+			cfg.NTPv5.SupportedVersions = tc.versions
+			body := v5Query(t, cfg, ef{efServerInfo, make([]byte, 4)}).get(t, efServerInfo)
+			if got := binary.BigEndian.Uint16(body); got != tc.bits {
+				t.Errorf("versies %v: %#04x, verwacht %#04x", tc.versions, got, tc.bits)
+			}
+		}
+	})
+
+	t.Run("server cookie en wrong_client_cookie", func(t *testing.T) {
+		cfg := v5Config()
+		resp := v5Exchange(t, cfg)
+		if cookie := binary.BigEndian.Uint64(resp[16:]); cookie != 0 {
+			t.Errorf("server cookie %#x zonder interleaved, verwacht 0", cookie)
+		}
+		if cookie := binary.BigEndian.Uint64(resp[24:]); cookie != clientCookie {
+			t.Errorf("client cookie %#x, verwacht %#x", cookie, uint64(clientCookie))
+		}
+
+		cfg.NTPv5.ServerCookie = 0x0123456789abcdef
+		cfg.NTPv5.WrongClientCookie = true
+		resp = v5Exchange(t, cfg)
+		if cookie := binary.BigEndian.Uint64(resp[16:]); cookie != 0x0123456789abcdef {
+			t.Errorf("server cookie %#x, verwacht 0x0123456789abcdef", cookie)
+		}
+		if cookie := binary.BigEndian.Uint64(resp[24:]); cookie == clientCookie {
+			t.Error("wrong_client_cookie: het client cookie komt ongewijzigd terug")
+		}
+	})
+
+	t.Run("auth_nak", func(t *testing.T) {
+		cfg := v5Config()
+		if flags := binary.BigEndian.Uint16(v5Exchange(t, cfg)[14:]); flags != 1 {
+			t.Errorf("flags %#04x, verwacht alleen synchronized", flags)
+		}
+		cfg.NTPv5.AuthNAK = true
+		if flags := binary.BigEndian.Uint16(v5Exchange(t, cfg)[14:]); flags != 1|1<<2 {
+			t.Errorf("flags %#04x, verwacht synchronized en authentication NAK", flags)
+		}
+	})
+
+	t.Run("draft ID", func(t *testing.T) {
+		for _, tc := range []struct{ cfg, want string }{
+			{"", "draft-ietf-ntp-ntpv5-09"},
+			{"draft-ietf-ntp-ntpv5-06", "draft-ietf-ntp-ntpv5-06"},
+		} {
+			cfg := v5Config()
+			cfg.NTPv5.DraftID = tc.cfg
+			body := v5Query(t, cfg, ef{efDraftID, nil}).get(t, efDraftID)
+			if got := string(bytes.TrimRight(body, "\x00")); got != tc.want {
+				t.Errorf("draft ID %q, verwacht %q", got, tc.want)
+			}
+		}
+	})
+
+	t.Run("correction als laatste", func(t *testing.T) {
+		correction := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+		efs := v5Query(t, v5Config(), ef{efCorrection, correction}, ef{efDraftID, nil}, ef{efServerInfo, make([]byte, 4)})
+		if last := efs.order[len(efs.order)-1]; last != efCorrection {
+			t.Errorf("laatste extension field %#04x, verwacht correction", last)
+		}
+		if got := efs.get(t, efCorrection); !bytes.Equal(got, correction) {
+			t.Errorf("correction %x, verwacht %x", got, correction)
+		}
+	})
+}
+
+const clientCookie = 0x1122334455667788
+
+type ef struct {
+	typ  uint16
+	body []byte
+}
+
+// extensionFields zijn de extension fields uit een antwoord, op type en in
+// volgorde.
+type extensionFields struct {
+	body  map[uint16][]byte
+	order []uint16
+}
+
+func (efs *extensionFields) get(t *testing.T, typ uint16) []byte {
+	t.Helper()
+	body, ok := efs.body[typ]
+	if !ok {
+		t.Fatalf("geen extension field %#04x in het antwoord", typ)
+	}
+	return body
+}
+
+func v5Config() fakentp.Config {
+	cfg := fakentp.DefaultConfig()
+	cfg.NTPv5.Enabled = true
+	return cfg
+}
+
+// v5Exchange start een server met cfg en stuurt één v5-verzoek met de
+// extension fields.
+func v5Exchange(t *testing.T, cfg fakentp.Config, fields ...ef) []byte {
+	t.Helper()
+	srv, addr, err := fakentp.Start(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	req := make([]byte, fakentp.NtpPacketSize)
+	req[0] = 5<<3 | 3
+	binary.BigEndian.PutUint64(req[24:], clientCookie)
+	for _, f := range fields {
+		length := 4 + (len(f.body)+3)&^3
+		req = binary.BigEndian.AppendUint16(req, f.typ)
+		req = binary.BigEndian.AppendUint16(req, uint16(length))
+		req = append(req, f.body...)
+		req = append(req, make([]byte, length-4-len(f.body))...)
+	}
+	resp, err := roundTrip(addr, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) < fakentp.NtpPacketSize || resp[0]>>3&7 != 5 {
+		t.Fatalf("geen v5-antwoord: %x", resp)
+	}
+	return resp
+}
+
+// v5Query is v5Exchange met de extension fields uit het antwoord gelezen.
+func v5Query(t *testing.T, cfg fakentp.Config, fields ...ef) *extensionFields {
+	t.Helper()
+	resp := v5Exchange(t, cfg, fields...)
+	efs := &extensionFields{body: map[uint16][]byte{}}
+	for pos := fakentp.NtpPacketSize; pos < len(resp); {
+		if pos+4 > len(resp) {
+			t.Fatalf("afgekapt extension field op byte %d", pos)
+		}
+		typ := binary.BigEndian.Uint16(resp[pos:])
+		length := int(binary.BigEndian.Uint16(resp[pos+2:]))
+		if length < 4 || length%4 != 0 || pos+length > len(resp) {
+			t.Fatalf("extension field %#04x met lengte %d op byte %d", typ, length, pos)
+		}
+		efs.body[typ] = resp[pos+4 : pos+length]
+		efs.order = append(efs.order, typ)
+		pos += length
+	}
+	return efs
+}
@@ -0,0 +1,712 @@
+package fakentp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+)
+
+// NTS (RFC 8915): een NTS-KE-listener (TLS 1.3, ALPN "ntske/1") die
+// cookies uitdeelt, en afhandeling van NTP-verzoeken met NTS extension
+// fields. De antwoorden zelf komen gewoon uit createFakeNTPResponse, zodat
+// alle Config-opties (scenario, client-profielen, KoD...) ook voor NTS gelden.
+
+type NTSConfig struct {
+	Enabled bool `json:"enabled"`
+	KEPort  int  `json:"ke_port"` // standaard 4460
+
+	// Eigen certificaat; zonder deze twee wordt er een self-signed
+	// certificaat gemaakt voor de namen in Hostnames.
+	CertFile  string   `json:"cert_file"`
+	KeyFile   string   `json:"key_file"`
+	Hostnames []string `json:"hostnames"` // standaard localhost, 127.0.0.1, ::1
+	CertOut   string   `json:"cert_out"`  // schrijf het self-signed certificaat (PEM) hierheen
+
+	// Server/Port Negotiation records. Zonder NTPServer stuurt de server
+	// geen Server-record (de client gebruikt dan de NTS-KE-host); het
+	// Port-record gaat mee zodra de NTP-poort niet 123 is.
+	NTPServer string `json:"ntp_server"`
+	NTPPort   int    `json:"ntp_port"` // standaard de poort van de NTP-server zelf
+
+	Cookies int `json:"cookies"` // aantal cookies per NTS-KE, standaard 8
+
+	// Foutinjectie, om te zien hoe clients reageren. Net als de rest van de
+	// config ook per scenario-fase of client-profiel te zetten.
+	FaultKEError        bool `json:"fault_ke_error"`         // NTS-KE antwoordt met een Error-record (Bad Request)
+	FaultNTPNAK         bool `json:"fault_ntp_nak"`          // elk NTS-verzoek krijgt een NTS NAK (KoD NTSN)
+	FaultBadAuthTag     bool `json:"fault_bad_auth_tag"`     // antwoord met een ongeldige authenticator-tag
+	FaultZeroCookies    bool `json:"fault_zero_cookies"`     // geen cookies, in NTS-KE noch in NTP-antwoorden
+	FaultBadAEAD        bool `json:"fault_bad_aead"`         // NTS-KE kiest een AEAD-algoritme dat de client niet aanbood
+	FaultOmitServerPort bool `json:"fault_omit_server_port"` // geen Server/Port-records, ook niet bij een andere poort
+	FaultExpiredCert    bool `json:"fault_expired_cert"`     // verlopen (self-signed) certificaat
+	FaultWrongALPN      bool `json:"fault_wrong_alpn"`       // server biedt alleen ALPN "ntske/2" aan
+
+	freeKEPort bool // via Start: zonder ke_port een vrije poort in plaats van 4460
+}
+
+const (
+	ntsKEALPN        = "ntske/1"
+	ntsExporterLabel = "EXPORTER-network-time-security"
+	ntsDefaultKEPort = 4460
+
+	// NTS-KE record types (RFC 8915, sectie 4)
+	keRecEnd       = 0
+	keRecNextProto = 1
+	keRecError     = 2
+	keRecWarning   = 3
+	keRecAEAD      = 4
+	keRecNewCookie = 5
+	keRecServer    = 6
+	keRecPort      = 7
+	keCritical     = 0x8000
+
+	keErrUnrecognizedCritical = 0
+	keErrBadRequest           = 1
+	keErrInternal             = 2
+
+	ntsProtoNTPv4     = 0
+	aeadAESSIVCMAC256 = 15
+	aeadAESSIVCMAC384 = 16 // deze drie door ons niet ondersteund, voor fault_bad_aead
+	aeadAESSIVCMAC512 = 17
+	aeadAES128GCMSIV  = 30
+
+	ntsWrongALPN = "ntske/2"
+
+	// NTP extension field types (RFC 8915, sectie 5)
+	efUniqueID          = 0x0104
+	efCookie            = 0x0204
+	efCookiePlaceholder = 0x0304
+	efAuthenticator     = 0x0404
+
+	ntsMaxCookies  = 8
+	ntsNonceSize   = 16
+	keMaxRecords   = 64
+	keReadDeadline = 10 * time.Second
+)
+
+var errNTSAuth = errors.New("NTS: cookie of authenticator ongeldig")
+
+type ntsKeys struct {
+	c2s []byte
+	s2c []byte
+}
+
+type ntsServer struct {
+	cookieAEAD  cipher.AEAD // versleutelt de cookies met een sleutel die alleen wij kennen
+	tlsConfig   *tls.Config
+	expiredCert tls.Certificate
+	ntpPort     int
+	debug       bool
+	configFor   func(time.Time, netip.Addr) Config
+	ln          net.Listener // NTS-KE
+}
+
+// ntsRequest is wat we uit een NTS-beveiligd verzoek halen.
+type ntsRequest struct {
+	uid     []byte
+	keys    ntsKeys
+	cookies int // aantal nieuwe cookies in het antwoord
+}
+
+func newNTSServer(cfg Config, configFor func(time.Time, netip.Addr) Config) (*ntsServer, error) {
+	masterKey := make([]byte, 32)
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, fmt.Errorf("NTS: kan geen sleutel maken: %v", err)
+	}
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("NTS: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("NTS: %v", err)
+	}
+
+	cert, err := loadOrCreateCertificate(cfg.NTS)
+	if err != nil {
+		return nil, err
+	}
+
+	// Voor fault_expired_cert: een certificaat dat gisteren is verlopen
+	now := time.Now()
+	expired, _, err := createCertificate(certHostnames(cfg.NTS), now.AddDate(0, 0, -31), now.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ntsServer{
+		cookieAEAD:  gcm,
+		expiredCert: expired,
+		ntpPort:     cfg.Port,
+		debug:       cfg.Debug,
+		configFor:   configFor,
+	}
+	s.tlsConfig = &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		NextProtos:         []string{ntsKEALPN},
+		MinVersion:         tls.VersionTLS13,
+		GetConfigForClient: s.tlsConfigForClient,
+	}
+	return s, nil
+}
+
+// tlsConfigForClient past fault_expired_cert en fault_wrong_alpn toe, per
+// client en per scenario-fase.
+func (s *ntsServer) tlsConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	cfg := s.configFor(time.Now(), addrOf(hello.Conn.RemoteAddr()))
+	if !cfg.NTS.FaultExpiredCert && !cfg.NTS.FaultWrongALPN {
+		return nil, nil
+	}
+
+	c := s.tlsConfig.Clone()
+	c.GetConfigForClient = nil
+	if cfg.NTS.FaultExpiredCert {
+		c.Certificates = []tls.Certificate{s.expiredCert}
+	}
+	if cfg.NTS.FaultWrongALPN {
+		c.NextProtos = []string{ntsWrongALPN}
+	}
+	return c, nil
+}
+
+func loadOrCreateCertificate(cfg NTSConfig) (tls.Certificate, error) {
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("NTS: kan certificaat niet laden: %v", err)
+		}
+		return cert, nil
+	}
+
+	hostnames := certHostnames(cfg)
+	now := time.Now()
+	cert, der, err := createCertificate(hostnames, now.Add(-time.Hour), now.AddDate(1, 0, 0))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Printf("NTS: self-signed certificaat voor %v, SHA-256 %x", hostnames, sha256.Sum256(der))
+
+	if cfg.CertOut != "" {
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		if err := os.WriteFile(cfg.CertOut, certPEM, 0o644); err != nil {
+			return tls.Certificate{}, fmt.Errorf("NTS: kan certificaat niet wegschrijven: %v", err)
+		}
+		log.Printf("NTS: certificaat weggeschreven naar %s", cfg.CertOut)
+	}
+	return cert, nil
+}
+
+func certHostnames(cfg NTSConfig) []string {
+	if len(cfg.Hostnames) == 0 {
+		return []string{"localhost", "127.0.0.1", "::1"}
+	}
+	return cfg.Hostnames
+}
+
+func createCertificate(hostnames []string, notBefore, notAfter time.Time) (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("NTS: kan geen sleutel maken: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostnames[0], Organization: []string{"Fake NTPD"}},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true, // zodat clients hem direct als root kunnen vertrouwen
+	}
+	for _, h := range hostnames {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("NTS: kan certificaat niet maken: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, der, nil
+}
+
+// ---------------------------------------------------------------------
+// NTS-KE
+// ---------------------------------------------------------------------
+
+// keListenAddr geeft het adres voor de NTS-KE-listener: het adres van de
+// NTP-socket (zonder address alle interfaces), op ke_port.
+func keListenAddr(cfg Config) string {
+	port := cfg.NTS.KEPort
+	if port == 0 && !cfg.NTS.freeKEPort {
+		port = ntsDefaultKEPort
+	}
+	return net.JoinHostPort(cfg.Address, strconv.Itoa(port))
+}
+
+func (s *ntsServer) listenKE(addr string) error {
+	network := "tcp"
+	if host, _, _ := net.SplitHostPort(addr); host != "" {
+		// Net als bij UDP: 0.0.0.0 alleen IPv4 en :: alleen IPv6
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("Ongeldig NTS-KE-adres %q: %v", addr, err)
+		}
+		network = "tcp6"
+		if tcpAddr.IP.To4() != nil {
+			network = "tcp4"
+		}
+	}
+	ln, err := tls.Listen(network, addr, s.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("Kan niet luisteren op TCP %s (NTS-KE): %v", addr, err)
+	}
+	s.ln = ln
+	log.Println("NTS-KE gestart op", ln.Addr())
+	go s.serveKE()
+	return nil
+}
+
+func (s *ntsServer) serveKE() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("NTS-KE: %v", err)
+			continue
+		}
+		go s.handleKE(conn.(*tls.Conn))
+	}
+}
+
+func (s *ntsServer) close() {
+	if s.ln != nil {
+		s.ln.Close()
+	}
+}
+
+type keRecord struct {
+	typ  uint16 // zonder critical bit
+	body []byte
+}
+
+func (s *ntsServer) handleKE(conn *tls.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(keReadDeadline))
+
+	remote := conn.RemoteAddr().String()
+	if err := conn.Handshake(); err != nil {
+		if s.debug {
+			fmt.Printf("NTS-KE van %s: TLS-handshake mislukt: %v\n", remote, err)
+		}
+		return
+	}
+	if conn.ConnectionState().NegotiatedProtocol != ntsKEALPN {
+		if s.debug {
+			fmt.Printf("NTS-KE van %s: geen ALPN %s\n", remote, ntsKEALPN)
+		}
+		return
+	}
+
+	records, err := readKERecords(conn)
+	if err != nil {
+		if s.debug {
+			fmt.Printf("NTS-KE van %s: %v\n", remote, err)
+		}
+		writeKERecords(conn, keErrorRecords(keErrBadRequest))
+		return
+	}
+
+	cfg := s.configFor(time.Now(), addrOf(conn.RemoteAddr()))
+
+	resp, err := s.keResponse(conn, cfg, records)
+	if err != nil {
+		if s.debug {
+			fmt.Printf("NTS-KE van %s: %v\n", remote, err)
+		}
+	}
+	if err := writeKERecords(conn, resp); err != nil && s.debug {
+		fmt.Printf("NTS-KE naar %s: %v\n", remote, err)
+	}
+}
+
+func readKERecords(r io.Reader) ([]keRecord, error) {
+	var records []keRecord
+	for len(records) < keMaxRecords {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, fmt.Errorf("record lezen: %v", err)
+		}
+		rec := keRecord{
+			typ:  binary.BigEndian.Uint16(hdr[0:2]) &^ keCritical,
+			body: make([]byte, binary.BigEndian.Uint16(hdr[2:4])),
+		}
+		if _, err := io.ReadFull(r, rec.body); err != nil {
+			return nil, fmt.Errorf("record lezen: %v", err)
+		}
+		if rec.typ == keRecEnd {
+			return records, nil
+		}
+		records = append(records, rec)
+	}
+	return nil, errors.New("te veel records")
+}
+
+// unofferedAEAD kiest voor fault_bad_aead een algoritme dat de client niet
+// aanbood, zodat die het moet weigeren. Clients bieden naast AES-SIV-CMAC-256
+// vaak ook AES-128-GCM-SIV (30) aan.
+func unofferedAEAD(offered map[uint16]bool) uint16 {
+	for _, id := range []uint16{aeadAES128GCMSIV, aeadAESSIVCMAC384, aeadAESSIVCMAC512} {
+		if !offered[id] {
+			return id
+		}
+	}
+	id := uint16(1)
+	for offered[id] {
+		id++
+	}
+	return id
+}
+
+// keResponse stelt het antwoord op een NTS-KE-verzoek samen. Bij een fout
+// komt er een Error-record terug, samen met de fout voor de debug-uitvoer.
+func (s *ntsServer) keResponse(conn *tls.Conn, cfg Config, records []keRecord) ([]keRecord, error) {
+	var protoOK, aeadOK, sawProto, sawAEAD bool
+	offered := map[uint16]bool{}
+	for _, rec := range records {
+		switch rec.typ {
+		case keRecNextProto:
+			sawProto = true
+			for i := 0; i+1 < len(rec.body); i += 2 {
+				if binary.BigEndian.Uint16(rec.body[i:]) == ntsProtoNTPv4 {
+					protoOK = true
+				}
+			}
+		case keRecAEAD:
+			sawAEAD = true
+			for i := 0; i+1 < len(rec.body); i += 2 {
+				offered[binary.BigEndian.Uint16(rec.body[i:])] = true
+			}
+			aeadOK = offered[aeadAESSIVCMAC256]
+		case keRecServer, keRecPort, keRecWarning, keRecError, keRecNewCookie:
+			// door een client niet te sturen, of niet van belang
+		}
+	}
+	if !sawProto || !sawAEAD {
+		return keErrorRecords(keErrBadRequest), errors.New("Next Protocol of AEAD record ontbreekt")
+	}
+	if !protoOK {
+		// Geen gemeenschappelijk protocol: lege Next Protocol-lijst
+		return []keRecord{{typ: keRecNextProto | keCritical}}, errors.New("client biedt geen NTPv4 aan")
+	}
+	if !aeadOK {
+		// Geen gemeenschappelijk algoritme: leeg AEAD-record
+		return []keRecord{{typ: keRecNextProto | keCritical, body: u16(ntsProtoNTPv4)}, {typ: keRecAEAD}},
+			errors.New("client biedt geen AES-SIV-CMAC-256 aan")
+	}
+
+	if cfg.NTS.FaultKEError {
+		return keErrorRecords(keErrBadRequest), errors.New("fault_ke_error: Error-record gestuurd")
+	}
+
+	keys, err := exportNTSKeys(conn)
+	if err != nil {
+		return keErrorRecords(keErrInternal), err
+	}
+
+	aead := uint16(aeadAESSIVCMAC256)
+	if cfg.NTS.FaultBadAEAD {
+		aead = unofferedAEAD(offered)
+	}
+	resp := []keRecord{
+		{typ: keRecNextProto | keCritical, body: u16(ntsProtoNTPv4)},
+		{typ: keRecAEAD, body: u16(aead)},
+	}
+	if !cfg.NTS.FaultOmitServerPort {
+		if cfg.NTS.NTPServer != "" {
+			resp = append(resp, keRecord{typ: keRecServer, body: []byte(cfg.NTS.NTPServer)})
+		}
+		port := cfg.NTS.NTPPort
+		if port == 0 {
+			port = s.ntpPort
+		}
+		if port != 123 {
+			resp = append(resp, keRecord{typ: keRecPort, body: u16(uint16(port))})
+		}
+	}
+
+	n := cfg.NTS.Cookies
+	if n <= 0 {
+		n = ntsMaxCookies
+	}
+	if cfg.NTS.FaultZeroCookies {
+		n = 0
+	}
+	for i := 0; i < n; i++ {
+		cookie, err := s.makeCookie(keys)
+		if err != nil {
+			return keErrorRecords(keErrInternal), err
+		}
+		resp = append(resp, keRecord{typ: keRecNewCookie, body: cookie})
+	}
+
+	if s.debug {
+		fmt.Printf("NTS-KE van %s: %d cookies uitgedeeld\n", conn.RemoteAddr(), n)
+	}
+	return resp, nil
+}
+
+func keErrorRecords(code uint16) []keRecord {
+	return []keRecord{{typ: keRecError | keCritical, body: u16(code)}}
+}
+
+func writeKERecords(w io.Writer, records []keRecord) error {
+	var buf []byte
+	for _, rec := range append(records, keRecord{typ: keRecEnd | keCritical}) {
+		buf = binary.BigEndian.AppendUint16(buf, rec.typ)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(rec.body)))
+		buf = append(buf, rec.body...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func exportNTSKeys(conn *tls.Conn) (ntsKeys, error) {
+	state := conn.ConnectionState()
+	// Context: protocol-ID, AEAD-ID, 0x00 = client naar server, 0x01 = andersom
+	c2s, err := state.ExportKeyingMaterial(ntsExporterLabel, []byte{0, ntsProtoNTPv4, 0, aeadAESSIVCMAC256, 0}, sivKeySize)
+	if err != nil {
+		return ntsKeys{}, err
+	}
+	s2c, err := state.ExportKeyingMaterial(ntsExporterLabel, []byte{0, ntsProtoNTPv4, 0, aeadAESSIVCMAC256, 1}, sivKeySize)
+	if err != nil {
+		return ntsKeys{}, err
+	}
+	return ntsKeys{c2s: c2s, s2c: s2c}, nil
+}
+
+func u16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+// ---------------------------------------------------------------------
+// Cookies: de sleutels van de client, versleuteld met onze eigen sleutel.
+// Het formaat is alleen voor onszelf: nonce || AES-GCM(c2s || s2c).
+// ---------------------------------------------------------------------
+
+func (s *ntsServer) makeCookie(keys ntsKeys) ([]byte, error) {
+	nonce := make([]byte, s.cookieAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	plain := append(append([]byte{}, keys.c2s...), keys.s2c...)
+	return s.cookieAEAD.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (s *ntsServer) openCookie(cookie []byte) (ntsKeys, error) {
+	ns := s.cookieAEAD.NonceSize()
+	if len(cookie) < ns {
+		return ntsKeys{}, errNTSAuth
+	}
+	plain, err := s.cookieAEAD.Open(nil, cookie[:ns], cookie[ns:], nil)
+	if err != nil || len(plain) != 2*sivKeySize {
+		return ntsKeys{}, errNTSAuth
+	}
+	return ntsKeys{c2s: plain[:sivKeySize], s2c: plain[sivKeySize:]}, nil
+}
+
+// ---------------------------------------------------------------------
+// NTP met NTS extension fields
+// ---------------------------------------------------------------------
+
+// parseNTSRequest haalt de NTS extension fields uit een verzoek en checkt
+// cookie en authenticator. Zonder NTS-velden geeft hij (nil, nil) terug:
+// een gewoon NTP-verzoek. Bij een ongeldig cookie of authenticator komt er
+// errNTSAuth terug; als de unique identifier bekend is, zit die in het
+// ntsRequest zodat er een NTS NAK gestuurd kan worden.
+func (s *ntsServer) parseNTSRequest(req []byte) (*ntsRequest, error) {
+	var (
+		r         ntsRequest
+		cookie    []byte
+		authStart int
+		authBody  []byte
+		isNTS     bool
+	)
+
+	for pos := NtpPacketSize; pos+4 <= len(req); {
+		typ := binary.BigEndian.Uint16(req[pos:])
+		length := int(binary.BigEndian.Uint16(req[pos+2:]))
+		if length < 4 || length%4 != 0 || pos+length > len(req) {
+			return nil, errors.New("NTS: ongeldig extension field")
+		}
+		body := req[pos+4 : pos+length]
+
+		switch typ {
+		case efUniqueID:
+			r.uid = body
+			isNTS = true
+		case efCookie:
+			if cookie == nil {
+				cookie = body
+			}
+			isNTS = true
+		case efCookiePlaceholder:
+			r.cookies++
+		case efAuthenticator:
+			authStart, authBody = pos, body
+			isNTS = true
+		}
+		pos += length
+		if authBody != nil {
+			// Alles na de authenticator is niet beveiligd: negeren
+			break
+		}
+	}
+
+	if !isNTS {
+		return nil, nil
+	}
+	if r.uid == nil {
+		return nil, errors.New("NTS: unique identifier ontbreekt")
+	}
+	if cookie == nil || authBody == nil {
+		return &r, errNTSAuth
+	}
+
+	keys, err := s.openCookie(cookie)
+	if err != nil {
+		return &r, err
+	}
+	r.keys = keys
+
+	nonce, ciphertext, err := parseAuthenticator(authBody)
+	if err != nil {
+		return &r, errNTSAuth
+	}
+	aead, err := newAESSIV(keys.c2s)
+	if err != nil {
+		return &r, err
+	}
+	plain, err := aead.Open(nonce, ciphertext, req[:authStart])
+	if err != nil {
+		return &r, errNTSAuth
+	}
+	// Versleutelde placeholders tellen ook mee
+	for pos := 0; pos+4 <= len(plain); {
+		length := int(binary.BigEndian.Uint16(plain[pos+2:]))
+		if length < 4 || pos+length > len(plain) {
+			break
+		}
+		if binary.BigEndian.Uint16(plain[pos:]) == efCookiePlaceholder {
+			r.cookies++
+		}
+		pos += length
+	}
+
+	// Eén cookie voor het gebruikte, plus één per placeholder
+	r.cookies++
+	if r.cookies > ntsMaxCookies {
+		r.cookies = ntsMaxCookies
+	}
+	return &r, nil
+}
+
+func parseAuthenticator(body []byte) (nonce, ciphertext []byte, err error) {
+	if len(body) < 4 {
+		return nil, nil, errNTSAuth
+	}
+	nonceLen := int(binary.BigEndian.Uint16(body[0:]))
+	ctLen := int(binary.BigEndian.Uint16(body[2:]))
+	nonceEnd := 4 + padTo4(nonceLen)
+	if nonceLen < ntsNonceSize || nonceEnd+ctLen > len(body) {
+		return nil, nil, errNTSAuth
+	}
+	return body[4 : 4+nonceLen], body[nonceEnd : nonceEnd+ctLen], nil
+}
+
+// wrapNTSResponse voegt de unique identifier en een authenticator met
+// nieuwe cookies toe aan een gewoon (48 bytes) NTP-antwoord.
+func (s *ntsServer) wrapNTSResponse(r *ntsRequest, header []byte, cfg NTSConfig) ([]byte, error) {
+	resp := appendEF(append([]byte{}, header[:NtpPacketSize]...), efUniqueID, r.uid)
+
+	cookies := r.cookies
+	if cfg.FaultZeroCookies {
+		cookies = 0
+	}
+	var plain []byte
+	for i := 0; i < cookies; i++ {
+		cookie, err := s.makeCookie(r.keys)
+		if err != nil {
+			return nil, err
+		}
+		plain = appendEF(plain, efCookie, cookie)
+	}
+
+	nonce := make([]byte, ntsNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	aead, err := newAESSIV(r.keys.s2c)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nonce, plain, resp)
+	if cfg.FaultBadAuthTag {
+		ciphertext[0] ^= 0xff
+	}
+
+	return appendEF(resp, efAuthenticator, authenticatorBody(nonce, ciphertext)), nil
+}
+
+func authenticatorBody(nonce, ciphertext []byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(nonce)))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(ciphertext)))
+	body = append(body, nonce...)
+	body = append(body, make([]byte, padTo4(len(nonce))-len(nonce))...)
+	body = append(body, ciphertext...)
+	return body
+}
+
+// ntsNAKResponse is een KoD NTSN met de unique identifier van de client,
+// zonder authenticator (RFC 8915, sectie 5.7).
+func ntsNAKResponse(req []byte, uid []byte) []byte {
+	return appendEF(createKoDResponse(req, "NTSN"), efUniqueID, uid)
+}
+
+// appendEF voegt een NTP extension field toe, aangevuld tot een veelvoud
+// van 4 bytes.
+func appendEF(b []byte, typ uint16, body []byte) []byte {
+	length := 4 + padTo4(len(body))
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(length))
+	b = append(b, body...)
+	return append(b, make([]byte, length-4-len(body))...)
+}
+
+func padTo4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,120 @@
+package fakentp_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+	"github.com/beevik/nts"
+
+	"fake-ntp-server/fakentp"
+)
+
+// TestNTSFaults: elke NTS-foutschakelaar, met een echte beevik/nts-client,
+// en de fout die die client dan geeft. Waar beevik/nts de fout niet
+// exporteert staat hier de exacte tekst; de exporter en ntsdetail delen ze
+// op dezelfde manier in (zie de README).
+func TestNTSFaults(t *testing.T) {
+	text := func(msg string) func(error) bool {
+		return func(err error) bool { return err != nil && err.Error() == msg }
+	}
+	expired := func(err error) bool {
+		var invalid x509.CertificateInvalidError
+		return errors.As(err, &invalid) && invalid.Reason == x509.Expired
+	}
+	noALPN := func(err error) bool {
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "remote error" && opErr.Err.Error() == "tls: no application protocol"
+	}
+
+	for _, tc := range []struct {
+		name  string
+		fault func(*fakentp.NTSConfig)
+		want  func(error) bool
+	}{
+		{"geen fout", func(*fakentp.NTSConfig) {}, func(err error) bool { return err == nil }},
+		{"fault_ke_error", func(c *fakentp.NTSConfig) { c.FaultKEError = true }, text("key exchange: bad request")},
+		{"fault_ntp_nak", func(c *fakentp.NTSConfig) { c.FaultNTPNAK = true }, is(nts.ErrAuthFailedOnServer)},
+		{"fault_bad_auth_tag", func(c *fakentp.NTSConfig) { c.FaultBadAuthTag = true }, is(nts.ErrAuthFailedOnClient)},
+		{"fault_zero_cookies", func(c *fakentp.NTSConfig) { c.FaultZeroCookies = true }, is(nts.ErrNoCookies)},
+		{"fault_bad_aead", func(c *fakentp.NTSConfig) { c.FaultBadAEAD = true }, text("key exchange: no supported algorithm negotiated")},
+		{"fault_expired_cert", func(c *fakentp.NTSConfig) { c.FaultExpiredCert = true }, expired},
+		{"fault_wrong_alpn", func(c *fakentp.NTSConfig) { c.FaultWrongALPN = true }, noALPN},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := ntsConfig(t)
+			tc.fault(&cfg.NTS)
+			srv, _, err := fakentp.Start(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+
+			if _, err := ntsQuery(srv.KEAddr().String(), trusting(t, cfg.NTS.CertOut)); !tc.want(err) {
+				t.Errorf("onverwachte fout: %v", err)
+			}
+		})
+	}
+}
+
+// TestNTSOmitServerPort: zonder Server/Port-records valt de client terug op
+// poort 123 van de NTS-KE-host. Welke fout dat geeft hangt af van wat daar
+// luistert, dus hier alleen het adres.
+func TestNTSOmitServerPort(t *testing.T) {
+	cfg := ntsConfig(t)
+	cfg.NTS.FaultOmitServerPort = true
+	srv, _, err := fakentp.Start(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	session, err := nts.NewSessionWithOptions(srv.KEAddr().String(), &nts.SessionOptions{TLSConfig: trusting(t, cfg.NTS.CertOut)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, port, _ := net.SplitHostPort(session.Address()); port != "123" {
+		t.Errorf("NTP-adres %s, verwacht poort 123", session.Address())
+	}
+}
+
+func is(target error) func(error) bool {
+	return func(err error) bool { return errors.Is(err, target) }
+}
+
+// ntsConfig is een NTS-server met een self-signed certificaat in een
+// tijdelijke map.
+func ntsConfig(t *testing.T) fakentp.Config {
+	cfg := fakentp.DefaultConfig()
+	cfg.NTS.Enabled = true
+	cfg.NTS.CertOut = filepath.Join(t.TempDir(), "nts.pem")
+	return cfg
+}
+
+// trusting vertrouwt alleen het certificaat uit cert_out.
+func trusting(t *testing.T, certOut string) *tls.Config {
+	pem, err := os.ReadFile(certOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		t.Fatalf("geen certificaat in %s", certOut)
+	}
+	return &tls.Config{RootCAs: pool, ServerName: "localhost"}
+}
+
+// ntsQuery doet de key exchange en één NTP-query, zoals de exporter.
+func ntsQuery(keAddr string, tlsConfig *tls.Config) (*ntp.Response, error) {
+	session, err := nts.NewSessionWithOptions(keAddr, &nts.SessionOptions{TLSConfig: tlsConfig, Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return session.QueryWithOptions(&ntp.QueryOptions{Timeout: 2 * time.Second})
+}
@@ -0,0 +1,64 @@
+package fakentp
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Willekeur per server. Met "seed" in de config zijn de antwoorden
+// reproduceerbaar: dezelfde seed en dezelfde reeks verzoeken geven
+// byte voor byte dezelfde antwoorden, op de echte tijd na. Zonder seed
+// kiest de server er zelf een en logt die, zodat een mislukte run toch na
+// te spelen is.
+//
+// Uit de seed worden aparte bronnen afgeleid voor de verzoeken en voor elk
+// random_walk-klokmodel; die laatste trekken op de klok in plaats van per
+// verzoek, en zouden de reeks anders van de timing laten afhangen. Om
+// dezelfde reden trekken /metrics en de control API niets. NTS-sleutels en
+// -cookies blijven echt willekeurig.
+
+// lockedRand is een math/rand-bron voor meerdere workers tegelijk.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+func (l *lockedRand) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Intn(n)
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+func (l *lockedRand) NormFloat64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.NormFloat64()
+}
+
+func (l *lockedRand) ExpFloat64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.ExpFloat64()
+}
+
+func (l *lockedRand) Uint32() uint32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Uint32()
+}
+
+func (l *lockedRand) Uint64() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Uint64()
+}
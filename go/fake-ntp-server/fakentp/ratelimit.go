@@ -0,0 +1,102 @@
+package fakentp
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// rateLimiter houdt per client-adres een token bucket bij. Elke client mag
+// gemiddeld rate verzoeken per seconde doen, met bursts tot burst verzoeken.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[netip.Addr]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Hoe vaak oude (weer volle) buckets worden opgeruimd
+const rateLimitPruneInterval = time.Minute
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets:   make(map[netip.Addr]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// allow meldt of addr op tijdstip now nog een verzoek mag doen, en neemt
+// zo ja een token uit de bucket.
+func (l *rateLimiter) allow(addr netip.Addr, now time.Time, rate float64, burst int) bool {
+	capacity := float64(burst)
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) >= rateLimitPruneInterval {
+		l.prune(now, rate, capacity)
+	}
+
+	b, ok := l.buckets[addr]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[addr] = b
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens += elapsed * rate
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune gooit buckets weg die inmiddels weer vol zouden zijn; die gedragen
+// zich hetzelfde als een nieuwe bucket.
+func (l *rateLimiter) prune(now time.Time, rate, capacity float64) {
+	for addr, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= capacity {
+			delete(l.buckets, addr)
+		}
+	}
+	l.lastPrune = now
+}
+
+// createKoDResponse maakt een Kiss-o'-Death zoals ntpd dat doet: LI=3,
+// stratum 0, de kiss code als refid, en de transmit timestamp van de client
+// teruggezet in origin (RFC 5905) - en ook in reference/receive/transmit,
+// zodat er geen bruikbare tijd in zit.
+func createKoDResponse(req []byte, code string) []byte {
+	version, _, _, _ := parseClientInfo(req)
+	li := uint8(3)
+	mode := uint8(4)
+
+	buf := make([]byte, NtpPacketSize)
+	buf[0] = (li << 6) | (version << 3) | mode
+	buf[1] = 0      // stratum 0: kiss code in de refid
+	buf[2] = req[2] // poll van de client
+	buf[3] = req[3] // precision van de client
+	// Bij stratum 0 is er geen willekeur nodig
+	binary.BigEndian.PutUint32(buf[12:], refIDFromType(code, 0, nil))
+	copy(buf[16:24], req[40:48])
+	copy(buf[24:32], req[40:48])
+	copy(buf[32:40], req[40:48])
+	copy(buf[40:48], req[40:48])
+	return buf
+}
@@ -0,0 +1,355 @@
+package fakentp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Replay: antwoorden van een echte server naspelen, uit een pcap of uit de
+// JSON-uitvoer van `ntpdetail -json`. Per opgenomen antwoord nemen we de
+// velden (stratum, refid, root delay/dispersion, precision, poll, leap) en
+// de gemeten offset over. De tijdlijn begint bij het starten van de
+// server; tussen twee opnames wordt de offset lineair geïnterpoleerd, de
+// overige velden komen van de laatste opname.
+
+type replayRecord struct {
+	at        time.Duration // sinds de eerste opname
+	offset    time.Duration // klok van de server min die van de client
+	leap      uint8
+	stratum   uint8
+	poll      int8
+	precision int8
+	refID     uint32
+	refAge    time.Duration // transmit time min reference time
+	rootDelay time.Duration
+	rootDisp  time.Duration
+}
+
+type replay struct {
+	records []replayRecord
+	loop    bool
+	start   time.Time
+	span    time.Duration
+}
+
+// loadReplay leest een pcap (herkend aan de magic number) of een reeks
+// ntpdetail-JSON-objecten.
+func loadReplay(path string, loop bool) (*replay, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []replayRecord
+	if len(data) >= 4 && isPcap(data) {
+		records, err = readPcapRecords(data)
+	} else {
+		records, err = readNTPDetailRecords(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("geen bruikbare NTP-antwoorden gevonden")
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].at < records[j].at })
+	first := records[0].at
+	for i := range records {
+		records[i].at -= first
+	}
+
+	r := &replay{records: records, loop: loop, start: time.Now()}
+	r.span = records[len(records)-1].at
+	if len(records) > 1 {
+		// Bij loop: na de laatste opname één gemiddeld interval wachten
+		r.span += r.span / time.Duration(len(records)-1)
+	}
+	return r, nil
+}
+
+// at geeft de opname voor tijdstip t, met geïnterpoleerde offset.
+func (r *replay) at(t time.Time) replayRecord {
+	elapsed := t.Sub(r.start)
+	if r.loop && r.span > 0 {
+		elapsed %= r.span
+	}
+
+	i := sort.Search(len(r.records), func(i int) bool { return r.records[i].at > elapsed })
+	if i == 0 {
+		return r.records[0]
+	}
+	rec := r.records[i-1]
+	if i < len(r.records) {
+		next := r.records[i]
+		if d := next.at - rec.at; d > 0 {
+			f := float64(elapsed-rec.at) / float64(d)
+			rec.offset += time.Duration(f * float64(next.offset-rec.offset))
+		}
+	}
+	return rec
+}
+
+// apply legt de opname over de config van een verzoek.
+func (rec *replayRecord) apply(cfg *Config) {
+	cfg.LeapIndicator = int(rec.leap)
+	cfg.MinStratum, cfg.MaxStratum = int(rec.stratum), int(rec.stratum)
+	cfg.MinPoll, cfg.MaxPoll = int(rec.poll), int(rec.poll)
+	cfg.MinPrecision, cfg.MaxPrecision = int(rec.precision), int(rec.precision)
+	cfg.MaxRefTimeOffset = int64(rec.refAge / time.Second)
+	cfg.replay = rec
+}
+
+// ntpdetailResult is het deel van ntpdetail's Result dat we nodig hebben.
+type ntpdetailResult struct {
+	LocalTime      time.Time     `json:"local_time"`
+	XmitTime       time.Time     `json:"xmit_time"`
+	RefTime        time.Time     `json:"ref_time"`
+	Offset         time.Duration `json:"offset_ns"`
+	PollExp        int8          `json:"poll_exponent"`
+	PrecisionExp   int8          `json:"precision_exponent"`
+	Stratum        uint8         `json:"stratum"`
+	RefIDRaw       uint32        `json:"ref_id_raw"`
+	RootDelay      time.Duration `json:"root_delay_ns"`
+	RootDispersion time.Duration `json:"root_dispersion_ns"`
+	LeapRaw        uint8         `json:"leap_raw"`
+}
+
+// readNTPDetailRecords leest de objecten van `ntpdetail -json`, ingesprongen
+// of één per regel. Mislukte queries (zonder transmit time) tellen niet mee.
+func readNTPDetailRecords(data []byte) ([]replayRecord, error) {
+	var records []replayRecord
+	var t0 time.Time
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var res ntpdetailResult
+		err := dec.Decode(&res)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ongeldige ntpdetail-JSON: %v", err)
+		}
+		if res.XmitTime.IsZero() || res.LocalTime.IsZero() {
+			continue
+		}
+		if t0.IsZero() {
+			t0 = res.LocalTime
+		}
+		rec := replayRecord{
+			at:        res.LocalTime.Sub(t0),
+			offset:    res.Offset,
+			leap:      res.LeapRaw,
+			stratum:   res.Stratum,
+			poll:      res.PollExp,
+			precision: res.PrecisionExp,
+			refID:     res.RefIDRaw,
+			rootDelay: res.RootDelay,
+			rootDisp:  res.RootDispersion,
+		}
+		if !res.RefTime.IsZero() {
+			rec.refAge = res.XmitTime.Sub(res.RefTime)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// pcap (libpcap-formaat, niet pcapng)
+
+func isPcap(data []byte) bool {
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		return true
+	case 0x0a0d0d0a:
+		return true // pcapng; readPcapRecords geeft een nette foutmelding
+	}
+	return false
+}
+
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkSLL2     = 276
+	linkIPv4     = 228
+	linkIPv6     = 229
+)
+
+// readPcapRecords zoekt NTP-antwoorden (mode 4, bronpoort 123). De offset
+// volgt uit de timestamps in het pakket, met de capture-tijd als T4; de
+// capture moet dus op de client gemaakt zijn.
+func readPcapRecords(data []byte) ([]replayRecord, error) {
+	if len(data) < 24 {
+		return nil, errors.New("pcap te kort")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	nano := false
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xa1b2c3d4:
+	case 0xa1b23c4d:
+		nano = true
+	case 0xd4c3b2a1:
+		order = binary.BigEndian
+	case 0x4d3cb2a1:
+		order, nano = binary.BigEndian, true
+	default:
+		return nil, errors.New("pcapng wordt niet ondersteund; zet om met: editcap -F pcap in.pcapng uit.pcap")
+	}
+	linkType := order.Uint32(data[20:24]) & 0x0fffffff
+
+	var records []replayRecord
+	var t0 time.Time
+	for pos := 24; pos < len(data); {
+		if len(data)-pos < 16 {
+			return nil, fmt.Errorf("pcap afgekapt: recordkop op byte %d", pos)
+		}
+		sec := int64(order.Uint32(data[pos:]))
+		sub := int64(order.Uint32(data[pos+4:]))
+		incl := uint64(order.Uint32(data[pos+8:]))
+		pos += 16
+		if incl > uint64(len(data)-pos) {
+			return nil, fmt.Errorf("pcap afgekapt: record van %d bytes op byte %d", incl, pos-16)
+		}
+		pkt := data[pos : pos+int(incl)]
+		pos += int(incl)
+
+		if !nano {
+			sub *= 1000
+		}
+		captured := time.Unix(sec, sub)
+
+		payload := udpPayloadFrom(pkt, linkType)
+		if len(payload) < NtpPacketSize || payload[0]&0x07 != 4 {
+			continue
+		}
+		if t0.IsZero() {
+			t0 = captured
+		}
+		records = append(records, recordFromResponse(payload, captured, captured.Sub(t0)))
+	}
+	return records, nil
+}
+
+// udpPayloadFrom geeft de UDP-payload van een pakket met bronpoort 123, of
+// nil.
+func udpPayloadFrom(pkt []byte, linkType uint32) []byte {
+	var ip []byte
+	switch linkType {
+	case linkEthernet:
+		if len(pkt) < 14 {
+			return nil
+		}
+		etherType, off := binary.BigEndian.Uint16(pkt[12:]), 14
+		for etherType == 0x8100 && len(pkt) >= off+4 { // VLAN-tags
+			etherType, off = binary.BigEndian.Uint16(pkt[off+2:]), off+4
+		}
+		ip = pkt[off:]
+	case linkSLL:
+		if len(pkt) < 16 {
+			return nil
+		}
+		ip = pkt[16:]
+	case linkSLL2:
+		if len(pkt) < 20 {
+			return nil
+		}
+		ip = pkt[20:]
+	case linkNull, linkLoop:
+		if len(pkt) < 4 {
+			return nil
+		}
+		ip = pkt[4:]
+	case linkRaw, linkIPv4, linkIPv6:
+		ip = pkt
+	default:
+		return nil
+	}
+	if len(ip) < 1 {
+		return nil
+	}
+
+	var udp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		ihl := int(ip[0]&0x0f) * 4
+		if len(ip) < 20 || ihl < 20 || len(ip) < ihl || ip[9] != 17 {
+			return nil
+		}
+		udp = ip[ihl:]
+	case 6:
+		// Zonder extension headers
+		if len(ip) < 40 || ip[6] != 17 {
+			return nil
+		}
+		udp = ip[40:]
+	default:
+		return nil
+	}
+	if len(udp) < 8 || binary.BigEndian.Uint16(udp) != 123 {
+		return nil
+	}
+	return udp[8:]
+}
+
+// recordFromResponse haalt de velden uit een NTP-antwoord; t4 is de tijd
+// waarop de client het ontving.
+func recordFromResponse(p []byte, t4 time.Time, at time.Duration) replayRecord {
+	t1 := ntpToTime(binary.BigEndian.Uint64(p[24:]))
+	t2 := ntpToTime(binary.BigEndian.Uint64(p[32:]))
+	t3 := ntpToTime(binary.BigEndian.Uint64(p[40:]))
+	ref := ntpToTime(binary.BigEndian.Uint64(p[16:]))
+
+	rec := replayRecord{
+		at:        at,
+		offset:    (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		leap:      p[0] >> 6,
+		stratum:   p[1],
+		poll:      int8(p[2]),
+		precision: int8(p[3]),
+		refID:     binary.BigEndian.Uint32(p[12:]),
+		rootDelay: shortToDuration(binary.BigEndian.Uint32(p[4:])),
+		rootDisp:  shortToDuration(binary.BigEndian.Uint32(p[8:])),
+	}
+	if binary.BigEndian.Uint64(p[16:]) != 0 {
+		rec.refAge = t3.Sub(ref)
+	}
+	return rec
+}
+
+// ntpToTime zet een 64-bit NTP-timestamp om. De era staat niet in de
+// timestamp; zoals in RFC 4330 is het met het hoogste bit gezet 1968–2036
+// (era 0) en anders 2036–2104 (era 1).
+func ntpToTime(ts uint64) time.Time {
+	sec := int64(ts>>32) - NtpEpochOffset
+	if ts>>63 == 0 {
+		sec += 1 << 32
+	}
+	nsec := int64((ts & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(sec, nsec)
+}
+
+// shortToDuration en durationToShort: NTP short format (16.16 seconden).
+func shortToDuration(v uint32) time.Duration {
+	return time.Duration(uint64(v) * 1e9 >> 16)
+}
+
+func durationToShort(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	v := (uint64(d)<<16 + 5e8) / 1e9
+	if v > 0xffffffff {
+		return 0xffffffff
+	}
+	return uint32(v)
+}
@@ -0,0 +1,211 @@
+package fakentp
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReadPcapRecords: pcaps in beide byte-volgordes en met verschillende
+// linktypes, frames die geen NTP-antwoord zijn, en afgekapte bestanden.
+func TestReadPcapRecords(t *testing.T) {
+	t4 := time.Date(2026, time.June, 25, 12, 0, 0, 0, time.UTC)
+	resp := ntpResponse(t4, 100*time.Millisecond)
+	ipv4 := ipv4UDP(123, 17, resp)
+
+	for _, tc := range []struct {
+		name    string
+		pcap    []byte
+		records int
+		err     string
+	}{
+		{"little-endian, µs, Ethernet", pcapFile(binary.LittleEndian, false, linkEthernet, t4, ethernet(0x0800, ipv4)), 1, ""},
+		{"big-endian, ns, Ethernet", pcapFile(binary.BigEndian, true, linkEthernet, t4, ethernet(0x0800, ipv4)), 1, ""},
+		{"VLAN-tag", pcapFile(binary.LittleEndian, false, linkEthernet, t4, ethernet(0x8100, append([]byte{0, 1, 0x08, 0}, ipv4...))), 1, ""},
+		{"raw IPv6", pcapFile(binary.BigEndian, false, linkRaw, t4, ipv6UDP(123, 17, resp)), 1, ""},
+		{"Linux cooked", pcapFile(binary.LittleEndian, true, linkSLL, t4, append(make([]byte, 16), ipv4...)), 1, ""},
+		{"twee antwoorden", pcapFile(binary.LittleEndian, false, linkRaw, t4, ipv4, ipv4), 2, ""},
+
+		{"TCP", pcapFile(binary.LittleEndian, false, linkEthernet, t4, ethernet(0x0800, ipv4UDP(123, 6, resp))), 0, ""},
+		{"ARP", pcapFile(binary.LittleEndian, false, linkEthernet, t4, ethernet(0x0806, make([]byte, 28))), 0, ""},
+		{"andere bronpoort", pcapFile(binary.LittleEndian, false, linkRaw, t4, ipv4UDP(1123, 17, resp)), 0, ""},
+		{"verzoek, geen antwoord", pcapFile(binary.LittleEndian, false, linkRaw, t4, ipv4UDP(123, 17, append([]byte{0x23}, resp[1:]...))), 0, ""},
+		{"te kort frame", pcapFile(binary.LittleEndian, false, linkEthernet, t4, []byte{1, 2, 3}), 0, ""},
+		{"onbekend linktype", pcapFile(binary.LittleEndian, false, 147, t4, ipv4), 0, ""},
+
+		{"afgekapt record", pcapFile(binary.LittleEndian, false, linkRaw, t4, ipv4)[:24+16+len(ipv4)-10], 0, "afgekapt"},
+		{"afgekapte recordkop", pcapFile(binary.LittleEndian, false, linkRaw, t4, ipv4, ipv4)[:24+16+len(ipv4)+8], 0, "afgekapt"},
+		{"record groter dan het bestand", withInclLen(pcapFile(binary.BigEndian, false, linkRaw, t4, ipv4), 0xffffffff), 0, "afgekapt"},
+		{"alleen een kop", pcapFile(binary.LittleEndian, false, linkRaw, t4)[:20], 0, "te kort"},
+		{"pcapng", []byte{0x0a, 0x0d, 0x0d, 0x0a, 0, 0, 0, 0, 0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, 0, "pcapng"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if !isPcap(tc.pcap) {
+				t.Fatal("niet herkend als pcap")
+			}
+			records, err := readPcapRecords(tc.pcap)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("fout %v, verwacht %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != tc.records {
+				t.Fatalf("%d opnames, verwacht %d", len(records), tc.records)
+			}
+			for _, rec := range records {
+				checkRecord(t, rec)
+			}
+		})
+	}
+}
+
+// TestReadNTPDetailRecords: uitvoer van ntpdetail -json, één object per
+// regel en ingesprongen door elkaar, met een mislukte query ertussen.
+func TestReadNTPDetailRecords(t *testing.T) {
+	const fixture = `{"host":"ntp.example.nl","local_time":"2026-06-25T12:00:00Z","offset_time":"2026-06-25T12:00:00.1Z","xmit_time":"2026-06-25T12:00:00.1Z","ref_time":"2026-06-25T11:59:44.1Z","rtt_ns":2000000,"offset_ns":100000000,"poll_exponent":6,"precision_exponent":-20,"stratum":2,"ref_id":"192.0.2.1","ref_id_raw":3221225985,"root_delay_ns":31250000,"root_dispersion_ns":15625000,"leap":"no warning","leap_raw":0,"valid":true}
+{"host":"ntp.example.nl","local_time":"2026-06-25T12:01:04Z","error":"read udp: i/o timeout","error_class":"timeout"}
+{
+  "host": "ntp.example.nl",
+  "local_time": "2026-06-25T12:02:08Z",
+  "xmit_time": "2026-06-25T12:02:08.1Z",
+  "ref_time": "2026-06-25T12:01:52.1Z",
+  "offset_ns": 100000000,
+  "poll_exponent": 6,
+  "precision_exponent": -20,
+  "stratum": 2,
+  "ref_id_raw": 3221225985,
+  "root_delay_ns": 31250000,
+  "root_dispersion_ns": 15625000,
+  "leap_raw": 0
+}
+`
+	records, err := readNTPDetailRecords([]byte(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d opnames, verwacht 2 (de mislukte query telt niet)", len(records))
+	}
+	if records[0].at != 0 || records[1].at != 128*time.Second {
+		t.Errorf("tijdstippen %v en %v, verwacht 0s en 2m8s", records[0].at, records[1].at)
+	}
+	for _, rec := range records {
+		checkRecord(t, rec)
+	}
+
+	for name, data := range map[string]string{
+		"afgekapt":      fixture[:len(fixture)-20],
+		"geen JSON":     "stratum 2\n",
+		"verkeerd type": `{"local_time":"2026-06-25T12:00:00Z","stratum":"twee"}`,
+	} {
+		if _, err := readNTPDetailRecords([]byte(data)); err == nil {
+			t.Errorf("%s: geen fout", name)
+		}
+	}
+}
+
+// checkRecord controleert de velden van ntpResponse en de fixture.
+func checkRecord(t *testing.T, rec replayRecord) {
+	t.Helper()
+	if d := rec.offset - 100*time.Millisecond; d.Abs() > time.Microsecond {
+		t.Errorf("offset %v, verwacht 100ms", rec.offset)
+	}
+	if rec.stratum != 2 || rec.poll != 6 || rec.precision != -20 || rec.leap != 0 {
+		t.Errorf("stratum %d, poll %d, precision %d, leap %d", rec.stratum, rec.poll, rec.precision, rec.leap)
+	}
+	if rec.refID != 0xc0000201 || rec.rootDelay != 31250*time.Microsecond || rec.rootDisp != 15625*time.Microsecond {
+		t.Errorf("refid %#x, root delay %v, root dispersion %v", rec.refID, rec.rootDelay, rec.rootDisp)
+	}
+	if d := rec.refAge - 16*time.Second; d.Abs() > time.Microsecond {
+		t.Errorf("reference time %v oud, verwacht 16s", rec.refAge)
+	}
+}
+
+// ntpResponse is een antwoord dat t4 ontvangen is, van een server die
+// offset voorloopt, zonder netwerkvertraging.
+func ntpResponse(t4 time.Time, offset time.Duration) []byte {
+	p := make([]byte, NtpPacketSize)
+	p[0] = 4<<3 | 4
+	p[1], p[2], p[3] = 2, 6, 0xec
+	binary.BigEndian.PutUint32(p[4:], 0x800) // 1/32 s
+	binary.BigEndian.PutUint32(p[8:], 0x400) // 1/64 s
+	binary.BigEndian.PutUint32(p[12:], 0xc0000201)
+	binary.BigEndian.PutUint64(p[16:], v5Timestamp(t4.Add(offset-16*time.Second)))
+	binary.BigEndian.PutUint64(p[24:], v5Timestamp(t4))
+	binary.BigEndian.PutUint64(p[32:], v5Timestamp(t4.Add(offset)))
+	binary.BigEndian.PutUint64(p[40:], v5Timestamp(t4.Add(offset)))
+	return p
+}
+
+func ipv4UDP(srcPort uint16, proto byte, payload []byte) []byte {
+	ip := make([]byte, 20, 28+len(payload))
+	ip[0], ip[8], ip[9] = 0x45, 64, proto
+	binary.BigEndian.PutUint16(ip[2:], uint16(28+len(payload)))
+	copy(ip[12:], []byte{192, 0, 2, 1, 192, 0, 2, 2})
+	return append(ip, udp(srcPort, payload)...)
+}
+
+func ipv6UDP(srcPort uint16, next byte, payload []byte) []byte {
+	ip := make([]byte, 40, 48+len(payload))
+	ip[0], ip[6], ip[7] = 0x60, next, 64
+	binary.BigEndian.PutUint16(ip[4:], uint16(8+len(payload)))
+	return append(ip, udp(srcPort, payload)...)
+}
+
+func udp(srcPort uint16, payload []byte) []byte {
+	h := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(h, srcPort)
+	binary.BigEndian.PutUint16(h[2:], 50123)
+	binary.BigEndian.PutUint16(h[4:], uint16(8+len(payload)))
+	return append(h, payload...)
+}
+
+func ethernet(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14, 14+len(payload))
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	return append(frame, payload...)
+}
+
+// pcapFile maakt een libpcap-bestand met de pakketten, allemaal
+// opgenomen op t.
+func pcapFile(order binary.ByteOrder, nano bool, linkType uint32, t time.Time, packets ...[]byte) []byte {
+	magic := uint32(0xa1b2c3d4)
+	if nano {
+		magic = 0xa1b23c4d
+	}
+	data := make([]byte, 24)
+	order.PutUint32(data, magic)
+	order.PutUint16(data[4:], 2)
+	order.PutUint16(data[6:], 4)
+	order.PutUint32(data[16:], 65535)
+	order.PutUint32(data[20:], linkType)
+
+	sub := uint32(t.Nanosecond() / 1000)
+	if nano {
+		sub = uint32(t.Nanosecond())
+	}
+	for _, p := range packets {
+		rec := make([]byte, 16)
+		order.PutUint32(rec, uint32(t.Unix()))
+		order.PutUint32(rec[4:], sub)
+		order.PutUint32(rec[8:], uint32(len(p)))
+		order.PutUint32(rec[12:], uint32(len(p)))
+		data = append(append(data, rec...), p...)
+	}
+	return data
+}
+
+// withInclLen zet de opgenomen lengte van het eerste record op n.
+func withInclLen(pcap []byte, n uint32) []byte {
+	order := binary.ByteOrder(binary.LittleEndian)
+	if binary.LittleEndian.Uint32(pcap) != 0xa1b2c3d4 && binary.LittleEndian.Uint32(pcap) != 0xa1b23c4d {
+		order = binary.BigEndian
+	}
+	order.PutUint32(pcap[24+8:], n)
+	return pcap
+}
@@ -0,0 +1,72 @@
+package fakentp
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Options zijn de bestanden waarmee het programma fake-ntpd start; de
+// vlaggen zelf staan in fake-ntpd.go.
+type Options struct {
+	ConfigPath   string // config.json
+	ScenarioPath string // scenariobestand, optioneel
+	ReplayPath   string // pcap of ntpdetail-JSON om na te spelen, optioneel
+	ReplayLoop   bool   // replay opnieuw beginnen na de laatste opname
+}
+
+// Run is het programma fake-ntpd: config, scenario en replay inlezen en
+// alle listeners starten. Als dat lukt blijft Run draaien; anders geeft
+// het de fout terug.
+func Run(opts Options) error {
+	cfg, err := loadConfig(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+
+	var scenario *Scenario
+	if opts.ScenarioPath != "" {
+		if scenario, err = loadScenario(opts.ScenarioPath, cfg); err != nil {
+			return err
+		}
+		log.Printf("Scenario geladen: %d fases", len(scenario.Phases))
+	}
+
+	var rp *replay
+	if opts.ReplayPath != "" {
+		if rp, err = loadReplay(opts.ReplayPath, opts.ReplayLoop); err != nil {
+			return fmt.Errorf("Kan replay niet inlezen: %v", err)
+		}
+		log.Printf("Replay geladen: %d antwoorden over %v", len(rp.records), rp.records[len(rp.records)-1].at)
+	}
+
+	// Metrics en exchange log zijn voor het hele proces
+	var xlog *exchangeLog
+	if cfg.ExchangeLog != "" {
+		if xlog, err = openExchangeLog(cfg.ExchangeLog); err != nil {
+			return fmt.Errorf("Kan exchange log niet openen: %v", err)
+		}
+	}
+	m := newMetrics()
+	m.registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	if cfg.MetricsAddr != "" {
+		if err := serveMetrics(cfg.MetricsAddr, m); err != nil {
+			return err
+		}
+	}
+
+	// Al gevalideerd in loadConfig
+	configs, _ := listenerConfigs(cfg)
+	for i, lcfg := range configs {
+		name := ""
+		if len(cfg.Listeners) > 0 {
+			name = listenerName(cfg, i)
+			log.Printf("Listener %q:", name)
+		}
+		if _, err := startServer(lcfg, name, scenario, rp, xlog, m); err != nil {
+			return err
+		}
+	}
+	select {}
+}
@@ -0,0 +1,156 @@
+package fakentp
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// Een scenario is een tijdlijn van fases. Elke fase overschrijft een deel
+// van de basis-Config (config.json) zolang ze actief is, bijvoorbeeld:
+//
+//	0-60s    gezond, stratum 1
+//	60-120s  leap_indicator 3
+//	120-180s offset +2s
+//	daarna   KoD RATE
+//
+// De overrides van een fase worden steeds op de basis-Config toegepast,
+// niet op die van de vorige fase.
+type Phase struct {
+	Name        string          `json:"name"`
+	DurationSec float64         `json:"duration_sec"` // 0 = blijft actief (alleen als laatste fase)
+	Config      json.RawMessage `json:"config"`
+}
+
+type Scenario struct {
+	Loop   bool    `json:"loop"`
+	Phases []Phase `json:"phases"`
+
+	start time.Time
+	total time.Duration
+}
+
+func loadScenario(path string, base Config) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Kan scenariobestand niet openen: %v", err)
+	}
+
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("Fout bij inlezen scenariobestand: %v", err)
+	}
+	if len(s.Phases) == 0 {
+		return nil, fmt.Errorf("Scenario %s bevat geen fases", path)
+	}
+
+	for i, p := range s.Phases {
+		if p.DurationSec < 0 {
+			return nil, fmt.Errorf("Fase %d (%q): negatieve duur", i, p.Name)
+		}
+		if p.DurationSec == 0 && (i != len(s.Phases)-1 || s.Loop) {
+			return nil, fmt.Errorf("Fase %d (%q): duur 0 mag alleen bij de laatste fase, zonder loop", i, p.Name)
+		}
+		s.total += time.Duration(p.DurationSec * float64(time.Second))
+	}
+	// Per listener volgt dit nog eens met diens basisconfig, in startServer
+	if _, err := s.resolve(base); err != nil {
+		return nil, err
+	}
+
+	s.start = time.Now()
+	return &s, nil
+}
+
+// applyOverride legt een (gedeeltelijke) JSON-config over een kopie van cfg:
+// alleen de velden die in raw voorkomen worden overschreven.
+func applyOverride(cfg Config, raw json.RawMessage) (Config, error) {
+	if len(raw) == 0 {
+		return cfg, nil
+	}
+	// json.Unmarshal schrijft in bestaande slices en pointers; die mogen we
+	// niet delen met de config waar andere workers uit lezen.
+	cfg = cfg.clone()
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("ongeldige config-override: %v", err)
+	}
+	return cfg, nil
+}
+
+// clone geeft een kopie van c zonder gedeelde slices of pointers.
+func (c Config) clone() Config {
+	c.Clients = slices.Clone(c.Clients)
+	for i := range c.Clients {
+		c.Clients[i].Match = slices.Clone(c.Clients[i].Match)
+		c.Clients[i].Config = slices.Clone(c.Clients[i].Config)
+	}
+	c.Clock = slices.Clone(c.Clock)
+	c.Upstream.Servers = slices.Clone(c.Upstream.Servers)
+	for i := range c.Clock {
+		c.Clock[i].Steps = slices.Clone(c.Clock[i].Steps)
+	}
+	c.NTS.Hostnames = slices.Clone(c.NTS.Hostnames)
+	c.Malform = slices.Clone(c.Malform)
+	c.Mode6.System = maps.Clone(c.Mode6.System)
+	c.Mode6.Peers = slices.Clone(c.Mode6.Peers)
+	for i := range c.Mode6.Peers {
+		c.Mode6.Peers[i].Vars = maps.Clone(c.Mode6.Peers[i].Vars)
+	}
+	c.Listeners = slices.Clone(c.Listeners)
+	for i := range c.Listeners {
+		c.Listeners[i].Config = slices.Clone(c.Listeners[i].Config)
+	}
+	c.Seed = clonePtr(c.Seed)
+	c.NTPv5.Timescale = clonePtr(c.NTPv5.Timescale)
+	c.NTPv5.Era = clonePtr(c.NTPv5.Era)
+	c.NTPv5.Synchronized = clonePtr(c.NTPv5.Synchronized)
+	c.NTPv5.UpstreamRefIDs = slices.Clone(c.NTPv5.UpstreamRefIDs)
+	c.NTPv5.SupportedVersions = slices.Clone(c.NTPv5.SupportedVersions)
+	return c
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// phaseAt geeft de index van de fase die op tijdstip t actief is.
+func (s *Scenario) phaseAt(t time.Time) int {
+	elapsed := t.Sub(s.start)
+	if s.Loop && s.total > 0 {
+		elapsed %= s.total
+	}
+
+	for i, p := range s.Phases {
+		d := time.Duration(p.DurationSec * float64(time.Second))
+		if p.DurationSec == 0 || elapsed < d {
+			return i
+		}
+		elapsed -= d
+	}
+	// Voorbij het einde zonder loop: de laatste fase blijft actief
+	return len(s.Phases) - 1
+}
+
+// resolve geeft de config van elke fase, over base gelegd. Dat gebeurt één
+// keer per basisconfig (bij het starten en na SetConfig), niet per pakket.
+func (s *Scenario) resolve(base Config) ([]Config, error) {
+	configs := make([]Config, len(s.Phases))
+	for i, p := range s.Phases {
+		cfg, err := applyOverride(base, p.Config)
+		if err == nil {
+			err = validateConfig(cfg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Fase %d (%q): %v", i, p.Name, err)
+		}
+		configs[i] = cfg
+	}
+	return configs, nil
+}
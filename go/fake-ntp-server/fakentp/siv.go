@@ -0,0 +1,175 @@
+package fakentp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// AEAD_AES_SIV_CMAC_256 (RFC 5297), het verplichte AEAD-algoritme voor NTS
+// (RFC 8915, algoritme-ID 15). De standaardbibliotheek heeft geen AES-SIV,
+// dus hier een eigen implementatie op basis van crypto/aes; de assembly van
+// secure-io/siv-go (die beevik/nts gebruikt) crasht op amd64 bij sommige
+// lengtes. siv_test.go zet haar tegen de voorbeelden uit RFC 5297 en
+// RFC 4493, en tegen siv-go.
+
+const (
+	sivKeySize = 32 // K1 (CMAC/S2V) + K2 (CTR), elk AES-128
+	sivTagSize = 16
+)
+
+var errSIVOpen = errors.New("aes-siv: authenticatie mislukt")
+
+type aesSIV struct {
+	mac    cipher.Block // K1
+	ctr    cipher.Block // K2
+	k1, k2 [16]byte     // CMAC-subsleutels
+}
+
+func newAESSIV(key []byte) (*aesSIV, error) {
+	if len(key) != sivKeySize {
+		return nil, errors.New("aes-siv: sleutel moet 32 bytes zijn")
+	}
+	mac, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[16:])
+	if err != nil {
+		return nil, err
+	}
+
+	s := &aesSIV{mac: mac, ctr: ctr}
+	var l [16]byte
+	mac.Encrypt(l[:], l[:])
+	s.k1 = dbl(l)
+	s.k2 = dbl(s.k1)
+	return s, nil
+}
+
+// Seal geeft V || C terug, met V de synthetische IV (tevens de tag).
+func (s *aesSIV) Seal(nonce, plaintext, ad []byte) []byte {
+	v := s.s2v(ad, nonce, plaintext)
+	out := make([]byte, sivTagSize+len(plaintext))
+	copy(out, v[:])
+	s.xorCTR(v, out[sivTagSize:], plaintext)
+	return out
+}
+
+func (s *aesSIV) Open(nonce, ciphertext, ad []byte) ([]byte, error) {
+	if len(ciphertext) < sivTagSize {
+		return nil, errSIVOpen
+	}
+	var v [16]byte
+	copy(v[:], ciphertext[:sivTagSize])
+
+	plaintext := make([]byte, len(ciphertext)-sivTagSize)
+	s.xorCTR(v, plaintext, ciphertext[sivTagSize:])
+
+	t := s.s2v(ad, nonce, plaintext)
+	if subtle.ConstantTimeCompare(t[:], v[:]) != 1 {
+		return nil, errSIVOpen
+	}
+	return plaintext, nil
+}
+
+func (s *aesSIV) xorCTR(v [16]byte, dst, src []byte) {
+	// Q = V met bit 31 en 63 (van rechts) op nul
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(dst, src)
+}
+
+// s2v is de "vector to string"-functie uit RFC 5297, sectie 2.4. Het
+// laatste element is de plaintext.
+func (s *aesSIV) s2v(components ...[]byte) [16]byte {
+	var zero [16]byte
+	d := s.cmac(zero[:])
+
+	last := len(components) - 1
+	for _, c := range components[:last] {
+		d = dbl(d)
+		m := s.cmac(c)
+		for i := range d {
+			d[i] ^= m[i]
+		}
+	}
+
+	sn := components[last]
+	var t []byte
+	if len(sn) >= 16 {
+		// xorend
+		t = make([]byte, len(sn))
+		copy(t, sn)
+		off := len(t) - 16
+		for i := range d {
+			t[off+i] ^= d[i]
+		}
+	} else {
+		d = dbl(d)
+		p := pad(sn)
+		for i := range d {
+			d[i] ^= p[i]
+		}
+		t = d[:]
+	}
+	return s.cmac(t)
+}
+
+// cmac is AES-CMAC (RFC 4493) met K1.
+func (s *aesSIV) cmac(m []byte) [16]byte {
+	var x [16]byte
+
+	n := (len(m) + 15) / 16
+	complete := n > 0 && len(m)%16 == 0
+	if n == 0 {
+		n = 1
+	}
+
+	for i := 0; i < n-1; i++ {
+		for j := 0; j < 16; j++ {
+			x[j] ^= m[i*16+j]
+		}
+		s.mac.Encrypt(x[:], x[:])
+	}
+
+	var lastBlock [16]byte
+	if complete {
+		copy(lastBlock[:], m[(n-1)*16:])
+		for j := range lastBlock {
+			lastBlock[j] ^= s.k1[j]
+		}
+	} else {
+		lastBlock = pad(m[(n-1)*16:])
+		for j := range lastBlock {
+			lastBlock[j] ^= s.k2[j]
+		}
+	}
+	for j := range x {
+		x[j] ^= lastBlock[j]
+	}
+	s.mac.Encrypt(x[:], x[:])
+	return x
+}
+
+// dbl is vermenigvuldigen met x in GF(2^128).
+func dbl(b [16]byte) [16]byte {
+	var out [16]byte
+	carry := b[0] >> 7
+	for i := 0; i < 15; i++ {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[15] = b[15] << 1
+	if carry == 1 {
+		out[15] ^= 0x87
+	}
+	return out
+}
+
+func pad(b []byte) [16]byte {
+	var out [16]byte
+	copy(out[:], b)
+	out[len(b)] = 0x80
+	return out
+}
@@ -0,0 +1,141 @@
+package fakentp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/secure-io/siv-go"
+)
+
+// TestCMAC: de voorbeelden uit RFC 4493, sectie 4, inclusief de
+// subsleutels K1 en K2.
+func TestCMAC(t *testing.T) {
+	s, err := newAESSIV(unhex(t, "2b7e151628aed2a6abf7158809cf4f3c"+strings.Repeat("00", 16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1 := hex.EncodeToString(s.k1[:]); k1 != "fbeed618357133667c85e08f7236a8de" {
+		t.Errorf("K1 %s", k1)
+	}
+	if k2 := hex.EncodeToString(s.k2[:]); k2 != "f7ddac306ae266ccf90bc11ee46d513b" {
+		t.Errorf("K2 %s", k2)
+	}
+
+	const m = "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710"
+	for _, tc := range []struct {
+		len int
+		mac string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		mac := s.cmac(unhex(t, m)[:tc.len])
+		if got := hex.EncodeToString(mac[:]); got != tc.mac {
+			t.Errorf("CMAC van %d bytes: %s, verwacht %s", tc.len, got, tc.mac)
+		}
+	}
+}
+
+// TestAESSIV: de voorbeelden uit RFC 5297, bijlage A.1 (deterministisch,
+// zonder nonce) en A.2 (twee keer associated data en een nonce).
+func TestAESSIV(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		key        string
+		components []string // associated data en nonce, in S2V-volgorde
+		plaintext  string
+		v, c       string
+	}{
+		{
+			name:       "A.1",
+			key:        "fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+			components: []string{"101112131415161718191a1b1c1d1e1f2021222324252627"},
+			plaintext:  "112233445566778899aabbccddee",
+			v:          "85632d07c6e8f37f950acd320a2ecc93",
+			c:          "40c02b9690c4dc04daef7f6afe5c",
+		},
+		{
+			name: "A.2",
+			key:  "7f7e7d7c7b7a79787776757473727170404142434445464748494a4b4c4d4e4f",
+			components: []string{
+				"00112233445566778899aabbccddeeffdeaddadadeaddadaffeeddccbbaa99887766554433221100",
+				"102030405060708090a0",
+				"09f911029d74e35bd84156c5635688c0",
+			},
+			plaintext: "7468697320697320736f6d6520706c61696e7465787420746f20656e6372797074207573696e67205349562d414553",
+			v:         "7bdb6e3b432667eb06f4d14bff2fbd0f",
+			c:         "cb900f2fddbe404326601965c889bf17dba77ceb094fa663b7a3f748ba8af829ea64ad544a272e9c485b62a3fd5c0d",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := newAESSIV(unhex(t, tc.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var components [][]byte
+			for _, c := range tc.components {
+				components = append(components, unhex(t, c))
+			}
+			plaintext := unhex(t, tc.plaintext)
+
+			v := s.s2v(append(components, plaintext)...)
+			if got := hex.EncodeToString(v[:]); got != tc.v {
+				t.Errorf("V %s, verwacht %s", got, tc.v)
+			}
+			c := make([]byte, len(plaintext))
+			s.xorCTR(v, c, plaintext)
+			if got := hex.EncodeToString(c); got != tc.c {
+				t.Errorf("C %s, verwacht %s", got, tc.c)
+			}
+		})
+	}
+}
+
+// TestAESSIVInterop: Seal en Open tegenover secure-io/siv-go, de
+// implementatie die beevik/nts aan clientzijde gebruikt.
+func TestAESSIVInterop(t *testing.T) {
+	key := make([]byte, sivKeySize)
+	nonce := make([]byte, ntsNonceSize)
+	rand.Read(key)
+	rand.Read(nonce)
+	ref, err := siv.NewCMAC(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newAESSIV(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, 1, 15, 16, 32, 100, 832} {
+		plaintext := make([]byte, n)
+		ad := make([]byte, 48+n)
+		rand.Read(plaintext)
+		rand.Read(ad)
+
+		sealed := s.Seal(nonce, plaintext, ad)
+		if want := ref.Seal(nil, nonce, plaintext, ad); !bytes.Equal(sealed, want) {
+			t.Errorf("%d bytes: Seal %x, siv-go %x", n, sealed, want)
+		}
+		if got, err := s.Open(nonce, sealed, ad); err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("%d bytes: Open %x, %v", n, got, err)
+		}
+		sealed[len(sealed)-1] ^= 1
+		if _, err := s.Open(nonce, sealed, ad); err == nil {
+			t.Errorf("%d bytes: Open accepteert een gewijzigde ciphertext", n)
+		}
+	}
+}
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
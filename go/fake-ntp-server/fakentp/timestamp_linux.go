@@ -0,0 +1,123 @@
+//go:build linux
+
+package fakentp
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"time"
+)
+
+// enableRxTimestamps zet SO_TIMESTAMPNS aan: de kernel levert dan bij elk
+// ontvangen pakket een (software) ontvangsttijd mee als control message.
+func enableRxTimestamps(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// rxTimestamp haalt de kernel-ontvangsttijd uit de control messages.
+func rxTimestamp(oob []byte) (time.Time, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, m := range msgs {
+		if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SCM_TIMESTAMPNS {
+			return parseTimespec(m.Data)
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseTimespec leest een struct timespec; 16 bytes op 64-bit, 8 bytes op
+// 32-bit platformen (arm, 386).
+func parseTimespec(b []byte) (time.Time, bool) {
+	switch {
+	case len(b) >= 16:
+		sec := int64(binary.NativeEndian.Uint64(b[0:8]))
+		nsec := int64(binary.NativeEndian.Uint64(b[8:16]))
+		return time.Unix(sec, nsec), true
+	case len(b) >= 8:
+		sec := int32(binary.NativeEndian.Uint32(b[0:4]))
+		nsec := int32(binary.NativeEndian.Uint32(b[4:8]))
+		return time.Unix(int64(sec), int64(nsec)), true
+	}
+	return time.Time{}, false
+}
+
+// Uit linux/net_tstamp.h; het syscall-pakket kent deze niet.
+const (
+	sofTimestampingTxSoftware = 1 << 1
+	sofTimestampingSoftware   = 1 << 4
+)
+
+// enableTxTimestamps vraagt de kernel om software-verzendtijden via
+// SO_TIMESTAMPING. Per verzonden pakket komt er dan een kopie met de
+// verzendtijd in de error queue van de socket.
+func enableTxTimestamps(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPING,
+			sofTimestampingTxSoftware|sofTimestampingSoftware)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// readTxTimestamps leest de error queue leeg zonder te blokkeren. Voor elk
+// pakket wordt found aangeroepen met de (teruggestuurde) pakketdata, inclusief
+// headers, en de verzendtijd volgens de kernel.
+func readTxTimestamps(conn *net.UDPConn, found func(data []byte, t time.Time)) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return
+	}
+	buf := make([]byte, MaxPacketSize+128)
+	oob := make([]byte, 256)
+	raw.Control(func(fd uintptr) {
+		for {
+			n, oobn, _, _, err := syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if err != nil {
+				return
+			}
+			if t, ok := txTimestamp(oob[:oobn]); ok {
+				found(buf[:n], t)
+			}
+		}
+	})
+}
+
+// txTimestamp haalt de software-tijd uit een SCM_TIMESTAMPING control
+// message (struct scm_timestamping: drie timespecs, de eerste is software).
+func txTimestamp(oob []byte) (time.Time, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, m := range msgs {
+		if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SO_TIMESTAMPING {
+			t, ok := parseTimespec(m.Data)
+			if ok && t.Unix() != 0 {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package fakentp
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+func enableRxTimestamps(conn *net.UDPConn) error {
+	return errors.New("kernel timestamps worden alleen op Linux ondersteund")
+}
+
+func rxTimestamp(oob []byte) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func enableTxTimestamps(conn *net.UDPConn) error {
+	return errors.New("kernel timestamps worden alleen op Linux ondersteund")
+}
+
+func readTxTimestamps(conn *net.UDPConn, found func(data []byte, t time.Time)) {}
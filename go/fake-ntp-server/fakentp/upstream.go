@@ -0,0 +1,177 @@
+package fakentp
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// Upstream volgen: in plaats van de systeemklok is de tijd van echte
+// NTP-servers de basis. Handig als de machine van de fake server zelf slecht
+// gesynchroniseerd is; afwijkingen uit de config gelden dan ten opzichte van
+// UTC, niet van de systeemklok:
+//
+//	"upstream": {"servers": ["ntp1.example.net", "192.0.2.10:123"], "interval_sec": 64}
+//
+// Per server blijven de laatste upstreamFilterSize metingen bewaard; zoals
+// het clock filter van ntpd telt de meting met de kleinste delay. Over de
+// servers heen geldt de mediaan. De server springt naar een nieuwe offset,
+// zonder slewing.
+
+type UpstreamConfig struct {
+	Servers     []string `json:"servers"`      // host of host:poort
+	IntervalSec float64  `json:"interval_sec"` // standaard 64
+	TimeoutMs   int      `json:"timeout_ms"`   // per verzoek, standaard 2000
+}
+
+const (
+	upstreamFilterSize = 8
+	// Een server die zo veel intervallen niet antwoordt, telt niet meer mee
+	upstreamMaxMissed = 8
+)
+
+func validateUpstream(cfg UpstreamConfig) error {
+	for i, s := range cfg.Servers {
+		if s == "" {
+			return fmt.Errorf("Upstream: server %d is leeg", i)
+		}
+	}
+	if cfg.IntervalSec < 0 || cfg.TimeoutMs < 0 {
+		return fmt.Errorf("Upstream: interval_sec en timeout_ms moeten >= 0 zijn")
+	}
+	return nil
+}
+
+// upstreamClock is het eerste klokmodel: de systeemklok plus de gefilterde
+// offset van de upstream servers.
+type upstreamClock struct {
+	servers  []*upstreamServer
+	interval time.Duration
+	timeout  time.Duration
+	debug    bool
+
+	offset atomic.Int64 // nanoseconden
+	synced atomic.Bool  // ooit een bruikbare meting gehad
+}
+
+type upstreamServer struct {
+	addr string
+
+	mu      sync.Mutex
+	samples []upstreamSample // de laatste upstreamFilterSize
+	lastOK  time.Time
+}
+
+type upstreamSample struct {
+	offset, delay time.Duration
+}
+
+func newUpstreamClock(cfg UpstreamConfig, debug bool) *upstreamClock {
+	c := &upstreamClock{
+		interval: 64 * time.Second,
+		timeout:  2 * time.Second,
+		debug:    debug,
+	}
+	if cfg.IntervalSec > 0 {
+		c.interval = time.Duration(cfg.IntervalSec * float64(time.Second))
+	}
+	if cfg.TimeoutMs > 0 {
+		c.timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+	}
+	for _, addr := range cfg.Servers {
+		c.servers = append(c.servers, &upstreamServer{addr: addr})
+	}
+	return c
+}
+
+func (c *upstreamClock) Adjust(t time.Time) time.Time {
+	return t.Add(time.Duration(c.offset.Load()))
+}
+
+// follow vraagt de servers elke interval opnieuw, tot done dicht gaat.
+func (c *upstreamClock) follow(done <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.poll()
+		case <-done:
+			return
+		}
+	}
+}
+
+// poll vraagt alle servers tegelijk en werkt de offset bij.
+func (c *upstreamClock) poll() {
+	var wg sync.WaitGroup
+	for _, s := range c.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.query(s)
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	var offsets []time.Duration
+	for _, s := range c.servers {
+		if off, ok := s.filtered(now, time.Duration(upstreamMaxMissed)*c.interval); ok {
+			offsets = append(offsets, off)
+		}
+	}
+	if len(offsets) == 0 {
+		if !c.synced.Load() {
+			log.Printf("Upstream: geen enkele server bereikbaar, de systeemklok blijft de basis")
+		}
+		return
+	}
+	slices.Sort(offsets)
+	// Bij een even aantal het gemiddelde van de middelste twee
+	median := (offsets[(len(offsets)-1)/2] + offsets[len(offsets)/2]) / 2
+	old := time.Duration(c.offset.Swap(int64(median)))
+	if !c.synced.Swap(true) {
+		log.Printf("Upstream: systeemklok wijkt %v af (%d van %d servers)", -median, len(offsets), len(c.servers))
+	} else if c.debug {
+		fmt.Printf("Upstream: offset %v (was %v, %d van %d servers)\n", median, old, len(offsets), len(c.servers))
+	}
+}
+
+func (c *upstreamClock) query(s *upstreamServer) {
+	r, err := ntp.QueryWithOptions(s.addr, ntp.QueryOptions{Version: 4, Timeout: c.timeout})
+	if err == nil {
+		err = r.Validate()
+	}
+	if err != nil {
+		if c.debug {
+			fmt.Printf("Upstream %s: %v\n", s.addr, err)
+		}
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, upstreamSample{r.ClockOffset, r.RTT})
+	if len(s.samples) > upstreamFilterSize {
+		s.samples = s.samples[1:]
+	}
+	s.lastOK = time.Now()
+}
+
+// filtered geeft de offset van de meting met de kleinste delay, als de
+// server recent nog geantwoord heeft.
+func (s *upstreamServer) filtered(now time.Time, maxAge time.Duration) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) == 0 || now.Sub(s.lastOK) > maxAge {
+		return 0, false
+	}
+	best := slices.MinFunc(s.samples, func(a, b upstreamSample) int { return cmp.Compare(a.delay, b.delay) })
+	return best.offset, true
+}
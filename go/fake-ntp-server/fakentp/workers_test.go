@@ -0,0 +1,105 @@
+package fakentp_test
+
+import (
+	"crypto/tls"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+	"github.com/beevik/nts"
+
+	"fake-ntp-server/fakentp"
+)
+
+// TestWorkersConcurrent stuurt vanuit meerdere goroutines tegelijk v4, v5,
+// NTS, mode 6 en mode 7 naar één server met meerdere workers. Vooral
+// zinvol met `go test -race`.
+func TestWorkersConcurrent(t *testing.T) {
+	cfg := fakentp.DefaultConfig()
+	cfg.Workers = 4
+	cfg.Interleaved = true
+	cfg.NTPv5.Enabled = true
+	cfg.NTS.Enabled = true
+	cfg.Mode6.Enabled = true
+	cfg.Mode6.Peers = []fakentp.Mode6Peer{{Vars: map[string]string{"srcadr": "192.0.2.1"}}}
+	cfg.Mode7.Monlist = true
+	cfg.Mode7.FakeEntries = 20
+	srv, addr, err := fakentp.Start(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	const rounds = 20
+	opt := ntp.QueryOptions{Timeout: 2 * time.Second}
+	clients := map[string]func() error{
+		"v4": func() error {
+			_, err := ntp.QueryWithOptions(addr.String(), opt)
+			return err
+		},
+		"v5": func() error {
+			o := opt
+			o.Version = 5
+			_, err := ntp.QueryWithOptions(addr.String(), o)
+			return err
+		},
+		"mode6": func() error {
+			// readvar, association 0
+			_, err := roundTrip(addr, []byte{0x16, 2, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0})
+			return err
+		},
+		"mode7": func() error {
+			// monlist (REQ_MON_GETLIST_1), implementation XNTPD
+			_, err := roundTrip(addr, []byte{0x17, 0, 3, 42, 0, 0, 0, 0})
+			return err
+		},
+	}
+
+	var wg sync.WaitGroup
+	for name, query := range clients {
+		for range 3 {
+			wg.Go(func() {
+				for range rounds {
+					if err := query(); err != nil {
+						t.Errorf("%s: %v", name, err)
+						return
+					}
+				}
+			})
+		}
+	}
+	for range 3 {
+		wg.Go(func() {
+			session, err := nts.NewSessionWithOptions(srv.KEAddr().String(), &nts.SessionOptions{
+				TLSConfig: &tls.Config{InsecureSkipVerify: true},
+				Timeout:   2 * time.Second,
+			})
+			if err != nil {
+				t.Errorf("NTS-KE: %v", err)
+				return
+			}
+			for range rounds {
+				o := opt // de sessie voegt er bij elke query zijn extension aan toe
+				if _, err := session.QueryWithOptions(&o); err != nil {
+					t.Errorf("NTS: %v", err)
+					return
+				}
+			}
+		})
+	}
+	// Ondertussen de config wijzigen, zoals de control API dat doet
+	wg.Go(func() {
+		c := srv.Config()
+		for i := range rounds {
+			c.TimeOffsetMs = i
+			if err := srv.SetConfig(c); err != nil {
+				t.Errorf("SetConfig: %v", err)
+				return
+			}
+			srv.Step(time.Millisecond)
+			time.Sleep(time.Millisecond)
+		}
+	})
+	wg.Wait()
+}
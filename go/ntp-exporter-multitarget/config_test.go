@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	caFile := writeCA(t, dir)
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		yaml  string
+		err   string // substring of the expected error; "" = must load
+		check func(*testing.T, *Module)
+	}{
+		{
+			name: "defaults",
+			yaml: "prober: ntp",
+			check: func(t *testing.T, m *Module) {
+				if m.Version != 4 || m.Port != 123 || m.KEPort != 4460 || m.Samples != 1 {
+					t.Errorf("defaults: version %d, port %d, ke_port %d, samples %d", m.Version, m.Port, m.KEPort, m.Samples)
+				}
+				if m.tlsConfig != nil {
+					t.Error("tlsConfig set without ca_file or insecure_skip_verify")
+				}
+			},
+		},
+		{name: "no prober", yaml: "timeout: 1s", err: "unknown prober"},
+		{name: "unknown prober", yaml: "prober: ptp", err: "unknown prober"},
+
+		// Durations
+		{
+			name: "durations",
+			yaml: "prober: ntp\ntimeout: 10s\nvalidation:\n  max_offset: 50ms\n  max_rtt: 1.5s\n  max_root_distance: 1m",
+			check: func(t *testing.T, m *Module) {
+				v := m.Validation
+				if m.Timeout != 10*time.Second || v.MaxOffset != 50*time.Millisecond || v.MaxRTT != 1500*time.Millisecond || v.MaxRootDistance != time.Minute {
+					t.Errorf("timeout %v, max_offset %v, max_rtt %v, max_root_distance %v", m.Timeout, v.MaxOffset, v.MaxRTT, v.MaxRootDistance)
+				}
+			},
+		},
+		{name: "duration without unit", yaml: "prober: ntp\ntimeout: 10", err: "parsing"},
+		{name: "malformed duration", yaml: "prober: ntp\nvalidation:\n  max_offset: fifty", err: "parsing"},
+		{name: "negative timeout", yaml: "prober: ntp\ntimeout: -1s", err: "must not be negative"},
+		{name: "negative samples", yaml: "prober: ntp\nsamples: -1", err: "must not be negative"},
+
+		// Versions and ports
+		{name: "version 1", yaml: "prober: ntp\nversion: 1", err: "version must be"},
+		{name: "nts with version 3", yaml: "prober: nts\nversion: 3", err: "NTS requires version 4"},
+		{name: "port 65535", yaml: "prober: ntp\nport: 65535"},
+		{name: "port 65536", yaml: "prober: ntp\nport: 65536", err: "out of range"},
+		{name: "negative port", yaml: "prober: ntp\nport: -1", err: "out of range"},
+		{name: "ke_port out of range", yaml: "prober: nts\nke_port: 70000", err: "out of range"},
+		{name: "ntp_port out of range", yaml: "prober: nts\nntp_server: ntp.example\nntp_port: 65536", err: "out of range"},
+		{name: "bad ip_protocol", yaml: "prober: ntp\nip_protocol: ip6", err: "ip_protocol"},
+
+		// ntp_port
+		{name: "ntp_port with ntp_server", yaml: "prober: nts\nntp_server: ntp.example\nntp_port: 1123"},
+		{name: "ntp_port without ntp_server", yaml: "prober: nts\nntp_port: 1123", err: "ntp_port requires ntp_server"},
+		{name: "ntp_port on the ntp prober", yaml: "prober: ntp\nntp_server: ntp.example\nntp_port: 1123", err: "only applies to the nts prober"},
+
+		// TLS
+		{
+			name: "ca_file",
+			yaml: "prober: nts\nca_file: " + caFile,
+			check: func(t *testing.T, m *Module) {
+				if m.tlsConfig == nil || m.tlsConfig.RootCAs == nil || m.tlsConfig.InsecureSkipVerify {
+					t.Errorf("tlsConfig %+v, want RootCAs from ca_file", m.tlsConfig)
+				}
+			},
+		},
+		{
+			name: "insecure_skip_verify",
+			yaml: "prober: nts\ninsecure_skip_verify: true",
+			check: func(t *testing.T, m *Module) {
+				if m.tlsConfig == nil || m.tlsConfig.RootCAs != nil || !m.tlsConfig.InsecureSkipVerify {
+					t.Errorf("tlsConfig %+v, want InsecureSkipVerify and the system roots", m.tlsConfig)
+				}
+			},
+		},
+		{name: "missing ca_file", yaml: "prober: nts\nca_file: " + filepath.Join(dir, "missing.pem"), err: "no such file"},
+		{name: "ca_file without certificates", yaml: "prober: nts\nca_file: " + notPEM, err: "no certificates found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			data := "modules:\n  test:\n    " + strings.ReplaceAll(tc.yaml, "\n", "\n    ") + "\n"
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := loadConfig(path)
+			switch {
+			case tc.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.err != "" && err == nil:
+				t.Fatalf("loaded, want an error containing %q", tc.err)
+			case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+				t.Fatalf("error %q, want it to contain %q", err, tc.err)
+			}
+			if err == nil && tc.check != nil {
+				tc.check(t, c.Modules["test"])
+			}
+		})
+	}
+}
+
+func TestLoadConfigEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	for _, data := range []string{"", "modules: {}\n", "modules:\n  test:\n"} {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("%q loaded, want an error", data)
+		}
+	}
+}
+
+// writeCA writes a self-signed CA certificate to dir and returns its path.
+func writeCA(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/beevik/ntp"
+	"github.com/beevik/nts"
+)
+
+// ---------------------------------------------------------------------
+// Error classes: the error_class label of ntp_last_error_info in the
+// exporter, and error_class in ntsdetail's output. This section is the
+// same, down to the end of the file, in ntp-exporter-multitarget/errclass.go
+// and ntsdetail_20260625.go; the exporter's errclass_test.go checks that
+// they still match, and that the beevik/nts messages below still exist in
+// the version the exporter builds against.
+// ---------------------------------------------------------------------
+
+const (
+	classTimeout              = "timeout"
+	classConnectionRefused    = "connection_refused"
+	classNetworkUnreachable   = "network_unreachable"
+	classDNSError             = "dns_error"
+	classKissOfDeath          = "kiss_of_death"
+	classCertificateExpired   = "nts_ke_certificate_expired"
+	classCertificateInvalid   = "nts_ke_certificate_invalid"
+	classALPNMismatch         = "nts_ke_alpn_mismatch"
+	classAEADUnsupported      = "nts_ke_aead_unsupported"
+	classKEErrorRecord        = "nts_ke_error_record"
+	classKEError              = "nts_ke_error"
+	classNoCookies            = "nts_no_cookies"
+	classNAK                  = "nts_nak"
+	classAuthenticationFailed = "nts_authentication_failed"
+	classInvalidResponse      = "nts_invalid_response"
+	classOther                = "other"
+)
+
+// keMessages are key exchange errors that beevik/nts does not export, by
+// their exact text.
+var keMessages = map[string]string{
+	"key exchange: NTS-KE protocol not negotiated":               classALPNMismatch,
+	"key exchange: server does not support requested algorithms": classAEADUnsupported,
+	"key exchange: no supported algorithm negotiated":            classAEADUnsupported,
+	"key exchange: unrecognized critical record":                 classKEErrorRecord,
+	"key exchange: bad request":                                  classKEErrorRecord,
+	"key exchange: internal server error":                        classKEErrorRecord,
+	"key exchange: NTP protocol not supported":                   classKEError,
+	"key exchange: failed to read record header":                 classKEError,
+	"key exchange: record length too large":                      classKEError,
+	"key exchange: failed to read record":                        classKEError,
+	"key exchange: invalid record size":                          classKEError,
+	"key exchange: incorrect critical bit":                       classKEError,
+}
+
+// keErrorPrefix starts every key exchange error from beevik/nts, also the
+// ones with a code in the text (unknown error or warning code).
+const keErrorPrefix = "key exchange: "
+
+// alertNoApplicationProtocol is the TLS alert from a server without an
+// ALPN protocol in common; crypto/tls returns it as a *net.OpError with
+// Op "remote error".
+const alertNoApplicationProtocol = "tls: no application protocol"
+
+// classifyError returns the class of err, or "" for nil. It goes by typed
+// errors (errors.Is, errors.As) wherever the libraries have them, and by
+// exact text only for the key exchange errors in keMessages.
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	// NTS after a successful key exchange, and KoD
+	switch {
+	case errors.Is(err, nts.ErrNoCookies):
+		return classNoCookies
+	case errors.Is(err, nts.ErrAuthFailedOnServer):
+		return classNAK // crypto-NAK, KoD NTSN
+	case errors.Is(err, nts.ErrAuthFailedOnClient), errors.Is(err, nts.ErrUniqueIDMismatch):
+		return classAuthenticationFailed
+	case errors.Is(err, nts.ErrInvalidFormat), errors.Is(err, nts.ErrMissingExtField):
+		return classInvalidResponse
+	case errors.Is(err, ntp.ErrKissOfDeath):
+		return classKissOfDeath
+	}
+
+	// NTS-KE: certificate and ALPN
+	var invalid x509.CertificateInvalidError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return classCertificateExpired
+	case errors.As(err, &invalid), errors.As(err, &unknownAuthority), errors.As(err, &hostname):
+		return classCertificateInvalid
+	case errors.As(err, &opErr) && opErr.Op == "remote error" && opErr.Err.Error() == alertNoApplicationProtocol:
+		return classALPNMismatch
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if class, ok := keMessages[e.Error()]; ok {
+			return class
+		}
+	}
+
+	// Network
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return classTimeout
+	case errors.As(err, &dnsErr):
+		return classDNSError
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return classConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return classNetworkUnreachable
+	}
+
+	if strings.HasPrefix(err.Error(), keErrorPrefix) {
+		return classKEError
+	}
+	return classOther
+}
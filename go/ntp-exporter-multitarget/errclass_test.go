@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/beevik/ntp"
+	"github.com/beevik/nts"
+)
+
+func TestClassifyError(t *testing.T) {
+	wrap := func(err error) error { return fmt.Errorf("key exchange: connection error: %w", err) }
+	type classCase struct {
+		err   error
+		class string
+	}
+	tests := []classCase{
+		{nil, ""},
+		{errors.New("something else"), classOther},
+
+		{nts.ErrNoCookies, classNoCookies},
+		{nts.ErrAuthFailedOnServer, classNAK},
+		{nts.ErrAuthFailedOnClient, classAuthenticationFailed},
+		{nts.ErrUniqueIDMismatch, classAuthenticationFailed},
+		{nts.ErrInvalidFormat, classInvalidResponse},
+		{nts.ErrMissingExtField, classInvalidResponse},
+		{ntp.ErrKissOfDeath, classKissOfDeath},
+		{fmt.Errorf("sample 2: %w", ntp.ErrKissOfDeath), classKissOfDeath},
+
+		{wrap(x509.CertificateInvalidError{Reason: x509.Expired}), classCertificateExpired},
+		{wrap(x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign}), classCertificateInvalid},
+		{wrap(x509.UnknownAuthorityError{}), classCertificateInvalid},
+		{wrap(x509.HostnameError{Host: "ntp.example"}), classCertificateInvalid},
+		{wrap(&net.OpError{Op: "remote error", Err: errors.New(alertNoApplicationProtocol)}), classALPNMismatch},
+		{wrap(&net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")}), classKEError},
+
+		{&net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}, classTimeout},
+		{wrap(&net.DNSError{Err: "i/o timeout", Name: "ntp.example", IsTimeout: true}), classTimeout},
+		{wrap(&net.DNSError{Err: "no such host", Name: "ntp.example", IsNotFound: true}), classDNSError},
+		{wrap(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), classConnectionRefused},
+		{&net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ECONNREFUSED)}, classConnectionRefused},
+		{wrap(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}), classNetworkUnreachable},
+		{wrap(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}), classNetworkUnreachable},
+
+		{errors.New("key exchange: unrecognized warning code (0x05)"), classKEError},
+		{errors.New("key exchange: unrecognized server error code (0x07)"), classKEError},
+	}
+	for msg, class := range keMessages {
+		tests = append(tests, classCase{errors.New(msg), class}, classCase{fmt.Errorf("wrapped: %w", errors.New(msg)), class})
+	}
+
+	for _, tc := range tests {
+		if got := classifyError(tc.err); got != tc.class {
+			t.Errorf("classifyError(%v) = %q, want %q", tc.err, got, tc.class)
+		}
+	}
+}
+
+// TestKEMessagesPinned checks keMessages and keErrorPrefix against the
+// source of the beevik/nts version in go.sum, so that a version that
+// renames an error fails here instead of quietly turning into "other".
+func TestKEMessagesPinned(t *testing.T) {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}} {{.Version}}", "github.com/beevik/nts").Output()
+	if err != nil {
+		t.Fatalf("go list github.com/beevik/nts: %v", err)
+	}
+	dir, version, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	src, err := os.ReadFile(filepath.Join(dir, "ntske.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for msg := range keMessages {
+		if !strings.Contains(string(src), strconv.Quote(msg)) {
+			t.Errorf("beevik/nts %s no longer has the error %q", version, msg)
+		}
+	}
+
+	literals := regexp.MustCompile(`(?:errors\.New|fmt\.Errorf)\(("[^"]*")`).FindAllSubmatch(src, -1)
+	if len(literals) == 0 {
+		t.Fatalf("no errors found in beevik/nts %s ntske.go", version)
+	}
+	for _, m := range literals {
+		msg, err := strconv.Unquote(string(m[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(msg, keErrorPrefix) {
+			t.Errorf("beevik/nts %s has a key exchange error without %q: %q", version, keErrorPrefix, msg)
+		}
+	}
+}
+
+// TestClassifierInSync checks that ntsdetail carries the same classifier.
+func TestClassifierInSync(t *testing.T) {
+	const marker = "// Error classes:"
+	section := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := strings.Index(string(data), marker)
+		if i < 0 {
+			t.Fatalf("%s has no %q section", path, marker)
+		}
+		return string(data[i:])
+	}
+	if section("errclass.go") != section(filepath.Join("..", "ntsdetail_20260625.go")) {
+		t.Error("the error classes in ../ntsdetail_20260625.go differ from errclass.go")
+	}
+}
@@ -11,30 +11,44 @@
 //	GET /probe?target=HOST&module=nts   - NTS key exchange + NTP query
 //	GET /probe?target=HOST&module=nts&ip_protocol=4  - force IPv4
 //	GET /metrics                         - exporter's own health/process metrics
+//	GET /config                          - modules in effect, defaults filled in
+//	POST /-/reload                       - re-read the -config.file
+//
+// With -config.file the modules come from a YAML file instead (see
+// config.go and ntp-exporter.yml): each one picks a prober, "ntp" or
+// "nts", and sets the NTP version, ports, TLS trust, requested NTP server,
+// address family, number of samples and validation thresholds. Send
+// SIGHUP to reload it.
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/beevik/ntp"
 	"github.com/beevik/nts"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	listenAddr     = flag.String("web.listen-address", ":9116", "Address to listen on")
 	defaultTimeout = flag.Duration("timeout", 5*time.Second, "Default probe timeout, used when Prometheus sends no scrape-timeout header")
 	timeoutOffset  = flag.Float64("timeout-offset", 0.5, "Seconds subtracted from the Prometheus scrape timeout to leave room for the response to be delivered")
+	configFile     = flag.String("config.file", "", "YAML file with probe modules; without it the built-in \"ntp\" and \"nts\" modules are used")
 )
 
 // probesTotal is a self-metric (on the default/exporter registry, not the
@@ -48,25 +62,38 @@ var probesTotal = prometheus.NewCounterVec(
 	[]string{"module", "result"},
 )
 
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ntp_exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload succeeded (1) or not (0)",
+	})
+	configReloadTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ntp_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload",
+	})
+)
+
 func init() {
-	prometheus.MustRegister(probesTotal)
+	prometheus.MustRegister(probesTotal, configReloadSuccess, configReloadTime)
 }
 
 // ---------------------------------------------------------------------
-// Probers: one function per module. Each fills in a fresh registry with
-// metrics for exactly one target and returns whether the probe overall
-// succeeded (could reach the server and parse a response).
+// Probers: one function per kind of module. Each fills in a fresh registry
+// with metrics for exactly one target and returns whether the probe overall
+// succeeded (could reach the server, parse a response and, if the module
+// sets thresholds, pass them).
 // ---------------------------------------------------------------------
 
-type prober func(target string, registry *prometheus.Registry, timeout time.Duration, family string) bool
+type prober func(target string, module *Module, registry *prometheus.Registry, timeout time.Duration, family string) bool
 
-var modules = map[string]prober{
+var probers = map[string]prober{
 	"ntp": probeNTP,
 	"nts": probeNTS,
 }
 
-func probeNTP(target string, registry *prometheus.Registry, timeout time.Duration, family string) bool {
-	opts := ntp.QueryOptions{Version: 4, Timeout: timeout}
+func probeNTP(target string, module *Module, registry *prometheus.Registry, timeout time.Duration, family string) bool {
+	deadline := time.Now().Add(timeout)
+	opts := ntp.QueryOptions{Version: module.Version}
 	if family != "" {
 		network := "udp" + family
 		opts.Dialer = func(_, addr string) (net.Conn, error) {
@@ -74,18 +101,25 @@ func probeNTP(target string, registry *prometheus.Registry, timeout time.Duratio
 		}
 	}
 
-	r, err := ntp.QueryWithOptions(target, opts)
-	if err != nil {
-		registerErrorMetric(registry, err)
-		return false
-	}
-	registerResponseMetrics(registry, r)
-	return true
+	address := withDefaultPort(target, module.Port)
+	return querySamples(registry, module, deadline, func(timeout time.Duration) (*ntp.Response, error) {
+		opts.Timeout = timeout
+		return ntp.QueryWithOptions(address, opts)
+	})
 }
 
-func probeNTS(target string, registry *prometheus.Registry, timeout time.Duration, family string) bool {
+func probeNTS(target string, module *Module, registry *prometheus.Registry, timeout time.Duration, family string) bool {
+	deadline := time.Now().Add(timeout)
 	sessOpts := &nts.SessionOptions{Timeout: timeout}
-	queryOpts := &ntp.QueryOptions{Version: 4, Timeout: timeout}
+	if module.tlsConfig != nil {
+		// The library may fill in fields; keep the shared one untouched
+		sessOpts.TLSConfig = module.tlsConfig.Clone()
+	}
+	if module.NTPServer != "" {
+		sessOpts.RequestedNTPServerAddress = module.NTPServer
+		sessOpts.RequestedNTPServerPort = module.NTPPort
+	}
+	queryOpts := &ntp.QueryOptions{Version: module.Version}
 	if family != "" {
 		tcpNetwork := "tcp" + family
 		udpNetwork := "udp" + family
@@ -98,7 +132,7 @@ func probeNTS(target string, registry *prometheus.Registry, timeout time.Duratio
 	}
 
 	keStart := time.Now()
-	session, err := nts.NewSessionWithOptions(target, sessOpts)
+	session, err := nts.NewSessionWithOptions(withDefaultPort(target, module.KEPort), sessOpts)
 	keDuration := time.Since(keStart)
 	newGauge(registry, "ntp_nts_handshake_duration_seconds", "Duration of the NTS-KE handshake in seconds").Set(keDuration.Seconds())
 	if err != nil {
@@ -107,13 +141,124 @@ func probeNTS(target string, registry *prometheus.Registry, timeout time.Duratio
 	}
 	newInfoMetric(registry, "ntp_nts_resolved_info", "NTP server address negotiated via the NTS-KE handshake", "ntp_server", session.Address())
 
-	r, err := session.QueryWithOptions(queryOpts)
-	if err != nil {
-		registerErrorMetric(registry, err)
+	return querySamples(registry, module, deadline, func(timeout time.Duration) (*ntp.Response, error) {
+		queryOpts.Timeout = timeout
+		return session.QueryWithOptions(queryOpts)
+	})
+}
+
+// querySamples sends the module's number of queries before the deadline,
+// each getting an equal share of the time that is left, and reports the
+// response with the lowest round trip time. A Kiss-o'-Death counts as a
+// failed query, not as a sample.
+func querySamples(registry *prometheus.Registry, module *Module, deadline time.Time, query func(timeout time.Duration) (*ntp.Response, error)) bool {
+	var best *ntp.Response
+	var lastErr error
+	kissCode := ""
+	received := 0
+	for i := 0; i < module.Samples; i++ {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		r, err := query(remaining / time.Duration(module.Samples-i))
+		if err == nil && r.IsKissOfDeath() {
+			// An answer, but not a time sample; beevik/ntp only reports
+			// it from Validate
+			kissCode = r.KissCode
+			err = fmt.Errorf("%w: %s", ntp.ErrKissOfDeath, r.KissCode)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		received++
+		if best == nil || r.RTT < best.RTT {
+			best = r
+		}
+	}
+	newGauge(registry, "ntp_samples_received", "Number of queries in this probe that got a response").Set(float64(received))
+
+	if best == nil {
+		if lastErr == nil {
+			lastErr = os.ErrDeadlineExceeded
+		}
+		registerErrorMetric(registry, lastErr)
+		if kissCode != "" {
+			newInfoMetric(registry, "ntp_kiss_code_info", "Kiss code if present", "kiss_code", kissCode)
+		}
 		return false
 	}
-	registerResponseMetrics(registry, r)
-	return true
+	registerResponseMetrics(registry, best)
+	return checkThresholds(registry, module.Validation, best)
+}
+
+// checkThresholds exposes every check the module configures as
+// ntp_validation_failed{check="..."} and reports whether all of them passed.
+func checkThresholds(registry *prometheus.Registry, v Validation, r *ntp.Response) bool {
+	failed := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ntp_validation_failed",
+		Help: "Whether the response failed a validation threshold of the module (1) or not (0)",
+	}, []string{"check"})
+	registry.MustRegister(failed)
+
+	ok := true
+	check := func(name string, enabled, bad bool) {
+		if !enabled {
+			return
+		}
+		value := 0.0
+		if bad {
+			value = 1
+			ok = false
+		}
+		failed.WithLabelValues(name).Set(value)
+	}
+	check("max_offset", v.MaxOffset > 0, r.ClockOffset.Abs() > v.MaxOffset)
+	check("max_rtt", v.MaxRTT > 0, r.RTT > v.MaxRTT)
+	check("max_root_distance", v.MaxRootDistance > 0, r.RootDistance > v.MaxRootDistance)
+	check("max_stratum", v.MaxStratum > 0, int(r.Stratum) > v.MaxStratum)
+	check("require_valid", v.RequireValid, r.Validate() != nil)
+	check("reject_leap", v.RejectLeap, r.Leap != ntp.LeapNoWarning)
+	return ok
+}
+
+// withDefaultPort appends port to target unless the target has one.
+func withDefaultPort(target string, port int) string {
+	if _, _, err := net.SplitHostPort(target); err == nil {
+		return target
+	}
+	return net.JoinHostPort(strings.Trim(target, "[]"), strconv.Itoa(port))
+}
+
+// chooseFamily applies a module's address family preference: the preferred
+// family if the target has an address in it, otherwise, with fallback, the
+// other one.
+func chooseFamily(target string, module *Module, timeout time.Duration) string {
+	preferred := module.IPProtocol
+	if preferred == "" || !module.IPProtocolFallback {
+		return preferred
+	}
+	host := strings.Trim(target, "[]")
+	if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Unmap().Is4() {
+			return "4"
+		}
+		return "6"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if ips, err := net.DefaultResolver.LookupIP(ctx, "ip"+preferred, host); err == nil && len(ips) > 0 {
+		return preferred
+	}
+	if preferred == "4" {
+		return "6"
+	}
+	return "4"
 }
 
 // registerResponseMetrics fills in the metric set shared by both modules -
@@ -144,33 +289,12 @@ func registerResponseMetrics(registry *prometheus.Registry, r *ntp.Response) {
 
 // registerErrorMetric reports a bounded-cardinality error classification
 // rather than the raw error string, which can vary per attempt (timeouts
-// embed addresses, etc.) and would otherwise churn the time series.
+// embed addresses, etc.) and would otherwise churn the time series. See
+// errclass.go for the classes.
 func registerErrorMetric(registry *prometheus.Registry, err error) {
 	newInfoMetric(registry, "ntp_last_error_info", "Classified error from the most recent failed probe", "error_class", classifyError(err))
 }
 
-func classifyError(err error) string {
-	var netErr net.Error
-	if errors.As(err, &netErr) && netErr.Timeout() {
-		return "timeout"
-	}
-	msg := err.Error()
-	switch {
-	case strings.Contains(msg, "connection refused"):
-		return "connection_refused"
-	case strings.Contains(msg, "no such host"):
-		return "dns_error"
-	case strings.Contains(msg, "network is unreachable"):
-		return "network_unreachable"
-	case strings.Contains(msg, "kiss of death") || strings.Contains(msg, "RATE"):
-		return "kiss_of_death"
-	case strings.Contains(msg, "key exchange"):
-		return "nts_ke_error"
-	default:
-		return "other"
-	}
-}
-
 // newGauge creates an unlabeled gauge, registers it on the given
 // (per-probe, throwaway) registry, and returns it for the caller to set.
 func newGauge(registry *prometheus.Registry, name, help string) prometheus.Gauge {
@@ -203,7 +327,7 @@ func probeHandler(w http.ResponseWriter, r *http.Request) {
 	if moduleName == "" {
 		moduleName = "ntp"
 	}
-	prober, ok := modules[moduleName]
+	module, ok := currentConfig.Load().Modules[moduleName]
 	if !ok {
 		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
 		return
@@ -231,13 +355,19 @@ func probeHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	if module.Timeout > 0 && module.Timeout < timeout {
+		timeout = module.Timeout
+	}
 
 	registry := prometheus.NewRegistry()
 	probeSuccess := newGauge(registry, "ntp_probe_success", "Whether the probe succeeded (1) or not (0)")
 	probeDuration := newGauge(registry, "ntp_probe_duration_seconds", "Duration of the probe in seconds")
 
 	start := time.Now()
-	success := prober(target, registry, timeout, family)
+	if family == "" {
+		family = chooseFamily(target, module, timeout)
+	}
+	success := probers[module.Prober](target, module, registry, time.Until(start.Add(timeout)), family)
 	probeDuration.Set(time.Since(start).Seconds())
 
 	result := "success"
@@ -257,14 +387,54 @@ func landingPageHandler(w http.ResponseWriter, r *http.Request) {
 <h1>NTP/NTS Exporter</h1>
 <p><a href="/probe?target=time.nl&module=ntp">Example: probe time.nl over plain NTP</a></p>
 <p><a href="/metrics">Exporter's own metrics</a></p>
+<p><a href="/config">Loaded modules</a></p>
 </body></html>`)
 }
 
+// reloadHandler re-reads the config file, like a SIGHUP.
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadConfig(*configFile); err != nil {
+		log.Printf("reloading config: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("config reloaded")
+}
+
+// configHandler shows the modules in effect, defaults filled in.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := yaml.NewEncoder(w).Encode(currentConfig.Load()); err != nil {
+		log.Printf("writing config: %v", err)
+	}
+}
+
 func main() {
 	flag.Parse()
 
+	if err := reloadConfig(*configFile); err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloadConfig(*configFile); err != nil {
+				log.Printf("reloading config, keeping the previous one: %v", err)
+			} else {
+				log.Printf("config reloaded")
+			}
+		}
+	}()
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/probe", probeHandler)
+	mux.HandleFunc("/-/reload", reloadHandler)
+	mux.HandleFunc("/config", configHandler)
 	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
 	mux.HandleFunc("/", landingPageHandler)
 
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestProbeKissOfDeath probes a server with rate limiting: once its burst
+// is used up it answers with a KoD RATE, which must fail the probe as
+// kiss_of_death instead of counting as a sample.
+func TestProbeKissOfDeath(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		burst    int
+		samples  int
+		ok       bool
+		received float64
+		class    string
+	}{
+		{"under the limit", 1, 1, true, 1, ""},
+		{"over the limit", 0, 1, false, 0, classKissOfDeath},
+		{"limit hit after one sample", 1, 3, true, 1, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := rateLimitedServer(t, tc.burst)
+			module := &Module{Prober: "ntp", Samples: tc.samples}
+			if err := module.validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			registry := prometheus.NewRegistry()
+			if ok := probeNTP(addr, module, registry, 2*time.Second, ""); ok != tc.ok {
+				t.Errorf("probe ok = %v, want %v", ok, tc.ok)
+			}
+			metrics := gather(t, registry)
+			if got := metrics["ntp_samples_received"]; got != tc.received {
+				t.Errorf("ntp_samples_received %v, want %v", got, tc.received)
+			}
+			if tc.class != "" {
+				if metrics[`ntp_last_error_info{error_class="`+tc.class+`"}`] != 1 || metrics[`ntp_kiss_code_info{kiss_code="RATE"}`] != 1 {
+					t.Errorf("no ntp_last_error_info for %q with kiss code RATE in %v", tc.class, metrics)
+				}
+			}
+		})
+	}
+}
+
+// rateLimitedServer answers the first burst requests with a stratum 1
+// response and every later one with a KoD RATE, the way ntpd does: LI 3,
+// stratum 0, and the client's transmit timestamp in origin, receive and
+// transmit.
+func rateLimitedServer(t *testing.T, burst int) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, client, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			resp := make([]byte, 48)
+			copy(resp[24:32], buf[40:48]) // origin = client transmit
+			if burst > 0 {
+				burst--
+				now := ntpTime(time.Now())
+				resp[0] = 4<<3 | 4
+				resp[1] = 1
+				copy(resp[12:16], "GPS\x00")
+				binary.BigEndian.PutUint64(resp[16:], now)
+				binary.BigEndian.PutUint64(resp[32:], now)
+				binary.BigEndian.PutUint64(resp[40:], now)
+			} else {
+				resp[0] = 3<<6 | 4<<3 | 4
+				copy(resp[12:16], "RATE")
+				copy(resp[32:40], buf[40:48])
+				copy(resp[40:48], buf[40:48])
+			}
+			resp[2], resp[3] = 6, 0xec // poll 64s, precision 2^-20
+			conn.WriteToUDP(resp, client)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func ntpTime(t time.Time) uint64 {
+	const ntpEpochOffset = 2208988800
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return sec<<32 | frac
+}
+
+// gather returns the gauges in registry by name and labels, e.g.
+// `ntp_last_error_info{error_class="timeout"}`.
+func gather(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for i, l := range m.GetLabel() {
+				sep := ","
+				if i == 0 {
+					sep = "{"
+				}
+				name += sep + l.GetName() + `="` + l.GetValue() + `"`
+			}
+			if len(m.GetLabel()) > 0 {
+				name += "}"
+			}
+			metrics[name] = m.GetGauge().GetValue()
+		}
+	}
+	return metrics
+}